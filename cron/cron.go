@@ -3,8 +3,10 @@ package cron
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
@@ -13,8 +15,9 @@ import (
 	"time"
 
 	"github.com/aptible/supercronic/crontab"
-	"github.com/aptible/supercronic/prometheus_metrics"
-	"github.com/prometheus/client_golang/prometheus"
+	"github.com/aptible/supercronic/metrics"
+	"github.com/aptible/supercronic/notify"
+	"github.com/aptible/supercronic/tracing"
 	"github.com/sirupsen/logrus"
 )
 
@@ -22,7 +25,32 @@ var (
 	READ_BUFFER_SIZE = 64 * 1024
 )
 
-func startReaderDrain(wg *sync.WaitGroup, readerLogger *logrus.Entry, reader io.ReadCloser) {
+// Options holds the settings that apply to every job started by StartJob.
+type Options struct {
+	Overlapping      bool
+	PassthroughLogs  bool
+	Hooks            Hooks
+	OutputTailLines  int
+	OutputTailBytes  int
+	MailOutputBytes  int
+	OutputFormat     OutputFormat
+	OutputJSONPrefix string
+	ChannelLevels    map[string]logrus.Level
+	LevelRules       []LevelRule
+	LongLines        LongLinePolicy
+	MaxLineLength    int
+	OutputRateLimit  float64
+	OutputBurst      int
+	OutputByteLimit  int64
+	OutputFiles      OutputFiles
+	Metrics          metrics.Recorder
+	Notifier         *notify.Dispatcher
+	Pinger           *notify.Pinger
+	Monitor          *notify.SentryMonitor
+	Tracer           *tracing.Tracer
+}
+
+func startReaderDrain(wg *sync.WaitGroup, readerLogger *logrus.Entry, channel string, reader io.ReadCloser, output *jobOutput) {
 	wg.Add(1)
 
 	go func() {
@@ -33,10 +61,17 @@ func startReaderDrain(wg *sync.WaitGroup, readerLogger *logrus.Entry, reader io.
 			wg.Done()
 		}()
 
-		bufReader := bufio.NewReaderSize(reader, READ_BUFFER_SIZE)
+		var source io.Reader = reader
+		if output.file != nil {
+			source = io.TeeReader(reader, output.file)
+		}
+
+		bufReader := bufio.NewReaderSize(source, READ_BUFFER_SIZE)
+		lines := output.newLineAssembler(readerLogger, channel)
+		defer lines.flush()
 
 		for {
-			line, isPrefix, err := bufReader.ReadLine()
+			chunk, isPrefix, err := bufReader.ReadLine()
 
 			if err != nil {
 				if strings.Contains(err.Error(), os.ErrClosed.Error()) {
@@ -54,16 +89,12 @@ func startReaderDrain(wg *sync.WaitGroup, readerLogger *logrus.Entry, reader io.
 				break
 			}
 
-			readerLogger.Info(string(line))
-
-			if isPrefix {
-				readerLogger.Warn("last line exceeded buffer size, continuing...")
-			}
+			lines.write(chunk, isPrefix)
 		}
 	}()
 }
 
-func runJob(cronCtx *crontab.Context, command string, jobLogger *logrus.Entry, passthroughLogs bool) error {
+func runJob(cronCtx *crontab.Context, command string, jobLogger *logrus.Entry, output *jobOutput) error {
 	jobLogger.Info("starting")
 
 	cmd := exec.Command(cronCtx.Shell, "-c", command)
@@ -82,7 +113,10 @@ func runJob(cronCtx *crontab.Context, command string, jobLogger *logrus.Entry, p
 	var stderr io.ReadCloser = nil
 	var err error
 
-	if passthroughLogs {
+	if output.passthrough && output.file != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, output.file)
+		cmd.Stderr = io.MultiWriter(os.Stderr, output.file)
+	} else if output.passthrough {
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
@@ -105,25 +139,41 @@ func runJob(cronCtx *crontab.Context, command string, jobLogger *logrus.Entry, p
 
 	if stdout != nil {
 		stdoutLogger := jobLogger.WithFields(logrus.Fields{"channel": "stdout"})
-		startReaderDrain(&wg, stdoutLogger, stdout)
+		startReaderDrain(&wg, stdoutLogger, "stdout", stdout, output)
 	}
 
 	if stderr != nil {
 		stderrLogger := jobLogger.WithFields(logrus.Fields{"channel": "stderr"})
-		startReaderDrain(&wg, stderrLogger, stderr)
+		startReaderDrain(&wg, stderrLogger, "stderr", stderr, output)
 	}
 
 	wg.Wait()
 
 	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("error running command: %v", err)
+		return fmt.Errorf("error running command: %w", err)
 	}
 
 	return nil
 }
 
-func monitorJob(ctx context.Context, job *crontab.Job, t0 time.Time, jobLogger *logrus.Entry, overlapping bool, promMetrics *prometheus_metrics.PrometheusMetrics) {
+// exitCode returns the exit code of the process that produced err, or -1 if
+// the process did not exit normally (e.g. it could not be started).
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func monitorJob(ctx context.Context, job *crontab.Job, t0 time.Time, cronIteration uint64, jobLogger *logrus.Entry, opts *Options) {
 	t := t0
+	notified := false
 
 	for {
 		t = job.Expression.Next(t)
@@ -131,13 +181,23 @@ func monitorJob(ctx context.Context, job *crontab.Job, t0 time.Time, jobLogger *
 		select {
 		case <-time.After(time.Until(t)):
 			m := "not starting"
-			if overlapping {
+			if opts.Overlapping {
 				m = "overlapping jobs"
 			}
 
 			jobLogger.Warnf("%s: job is still running since %s (%s elapsed)", m, t0, t.Sub(t0))
 
-			promMetrics.CronsDeadlineExceededCounter.With(jobPromLabels(job)).Inc()
+			opts.recorder().DeadlineExceeded(job)
+
+			if !notified {
+				event := jobEvent(job, notify.Timeout)
+				event.Iteration = cronIteration
+				event.Duration = t.Sub(t0)
+				event.Error = fmt.Sprintf("job is still running since %s", t0)
+				opts.Notifier.Dispatch(event)
+
+				notified = true
+			}
 		case <-ctx.Done():
 			return
 		}
@@ -151,7 +211,9 @@ func startFunc(
 	overlapping bool,
 	expression crontab.Expression,
 	timezone *time.Location,
-	fn func(time.Time, *logrus.Entry),
+	fn func(uint64, time.Time, *logrus.Entry),
+	onMissed func(time.Duration),
+	onScheduled func(time.Time),
 ) {
 	wg.Add(1)
 
@@ -175,10 +237,17 @@ func startFunc(
 			delay := nextRun.Sub(now)
 			if delay < 0 {
 				logger.Warningf("job took too long to run: it should have started %v ago", -delay)
+				if onMissed != nil {
+					onMissed(-delay)
+				}
 				nextRun = now
 				continue
 			}
 
+			if onScheduled != nil {
+				onScheduled(nextRun)
+			}
+
 			select {
 			case <-exitCtx.Done():
 				logger.Debug("shutting down")
@@ -200,7 +269,7 @@ func startFunc(
 					"iteration": cronIteration,
 				})
 
-				fn(nextRun, jobLogger)
+				fn(cronIteration, nextRun, jobLogger)
 			}
 
 			if overlapping {
@@ -220,58 +289,200 @@ func StartJob(
 	job *crontab.Job,
 	exitCtx context.Context,
 	cronLogger *logrus.Entry,
-	overlapping bool,
-	passthroughLogs bool,
-	promMetrics *prometheus_metrics.PrometheusMetrics,
+	opts *Options,
 ) {
-	runThisJob := func(t0 time.Time, jobLogger *logrus.Entry) {
-		promMetrics.CronsCurrentlyRunningGauge.With(jobPromLabels(job)).Inc()
+	recorder := opts.recorder()
+	jobOutputConfig := newOutputConfig(opts, job, cronLogger)
 
-		defer func() {
-			promMetrics.CronsCurrentlyRunningGauge.With(jobPromLabels(job)).Dec()
-		}()
+	slug := monitorSlug(job)
+	if _, ok := notify.SentryCrontab(job.Schedule); opts.Monitor != nil && slug != "" && !ok {
+		cronLogger.Warn("schedule not supported by Sentry Cron Monitors: check-ins are sent without a schedule")
+	}
+
+	runThisJob := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
+		recorder.RunStarted(job)
+		defer recorder.RunEnded(job)
 
 		monitorCtx, cancelMonitor := context.WithCancel(context.Background())
 		defer cancelMonitor()
 
-		go monitorJob(monitorCtx, job, t0, jobLogger, overlapping, promMetrics)
+		go monitorJob(monitorCtx, job, t0, cronIteration, jobLogger, opts)
+
+		ping := opts.Pinger.Start(pingURL(cronCtx, job))
+		checkIn := opts.Monitor.Start(slug, job.Schedule, cronCtx.Timezone)
+		span := opts.Tracer.Start(job, cronIteration)
 
-		timer := prometheus.NewTimer(prometheus.ObserverFunc(func(v float64) {
-			promMetrics.CronsExecutionTimeHistogram.With(jobPromLabels(job)).Observe(v)
-		}))
+		run := &hookRun{iteration: cronIteration}
+		runHooks(cronCtx, job, HookBefore, run, jobLogger, opts)
 
-		defer timer.ObserveDuration()
+		start := time.Now()
+		output := jobOutputConfig.newRun()
 
-		err := runJob(cronCtx, job.Command, jobLogger, passthroughLogs)
+		err := runJob(spanEnviron(cronCtx, span), job.Command, jobLogger, output)
+		outputPath := output.file.Close()
 
-		promMetrics.CronsExecCounter.With(jobPromLabels(job)).Inc()
+		if dropped := output.droppedLines(); len(dropped) > 0 {
+			fields := logrus.Fields{}
+			for channel, n := range dropped {
+				fields["dropped."+channel] = n
+				recorder.OutputDropped(job, channel, n)
+			}
+
+			jobLogger.WithFields(fields).Warn("job output exceeded its limits, some lines were not logged")
+		}
+
+		run.finished = true
+		run.duration = time.Since(start)
+		run.exitCode = exitCode(err)
+		run.output = output.tail.String()
+
+		recorder.RunFinished(job, run.duration, run.exitCode)
+
+		event := jobEvent(job, notify.Success)
+		event.Iteration = cronIteration
+		event.ExitCode = run.exitCode
+		event.Duration = run.duration
+		event.Output = output.tail.lines()
+		event.MailOutput = output.mail.lines()
+		event.MailTo = mailTo(cronCtx, job)
+		event.OutputFile = outputPath
+
+		if outputPath != "" {
+			jobLogger = jobLogger.WithField("output.file", outputPath)
+		}
 
 		if err == nil {
 			jobLogger.Info("job succeeded")
 
-			promMetrics.CronsSuccessCounter.With(jobPromLabels(job)).Inc()
+			runHooks(cronCtx, job, HookOnSuccess, run, jobLogger, opts)
 		} else {
-			jobLogger.Error(err)
+			errLogger := jobLogger.WithField("exit_code", run.exitCode)
+			if len(event.Output) > 0 {
+				errLogger = errLogger.WithField("output", run.output)
+			}
+			errLogger.Error(err)
+
+			event.Kind = notify.Failure
+			event.Error = err.Error()
 
-			promMetrics.CronsFailCounter.With(jobPromLabels(job)).Inc()
+			runHooks(cronCtx, job, HookOnFailure, run, jobLogger, opts)
 		}
+
+		opts.Notifier.Dispatch(event)
+		ping.Finish(run.exitCode, event.Output)
+		checkIn.Finish(run.exitCode)
+		span.Finish(run.exitCode, err)
+
+		runHooks(cronCtx, job, HookAfter, run, jobLogger, opts)
 	}
 
 	startFunc(
 		wg,
 		exitCtx,
 		cronLogger,
-		overlapping,
+		opts.Overlapping,
 		job.Expression,
 		cronCtx.Timezone,
 		runThisJob,
+		func(delay time.Duration) {
+			event := jobEvent(job, notify.Missed)
+			event.Error = fmt.Sprintf("job took too long to run: it should have started %v ago", delay)
+			opts.Notifier.Dispatch(event)
+		},
+		func(next time.Time) {
+			recorder.RunScheduled(job, next)
+		},
 	)
 }
 
-func jobPromLabels(job *crontab.Job) prometheus.Labels {
-	return prometheus.Labels{
-		"position": fmt.Sprintf("%d", job.Position),
-		"command":  job.Command,
-		"schedule": job.Schedule,
+// pingURL returns the URL the job should ping, taken from its "@ping"
+// annotation or the SUPERCRONIC_PING_URL variable (from the crontab or the
+// environment). "{name}" in the URL is replaced with the job name.
+func pingURL(cronCtx *crontab.Context, job *crontab.Job) string {
+	u := job.Annotations["ping"]
+
+	if u == "" {
+		u = cronCtx.Environ["SUPERCRONIC_PING_URL"]
+	}
+
+	if u == "" {
+		u = os.Getenv("SUPERCRONIC_PING_URL")
+	}
+
+	return strings.ReplaceAll(u, "{name}", url.PathEscape(job.Name))
+}
+
+// spanEnviron returns the context the job should run in, with the variables
+// that attach its own spans to the span of the run.
+func spanEnviron(cronCtx *crontab.Context, span *tracing.Span) *crontab.Context {
+	spanEnv := span.Environ()
+	if len(spanEnv) == 0 {
+		return cronCtx
+	}
+
+	environ := make(map[string]string, len(cronCtx.Environ)+len(spanEnv))
+	for k, v := range cronCtx.Environ {
+		environ[k] = v
+	}
+	for k, v := range spanEnv {
+		environ[k] = v
+	}
+
+	return &crontab.Context{
+		Shell:    cronCtx.Shell,
+		Environ:  environ,
+		Timezone: cronCtx.Timezone,
+	}
+}
+
+// monitorSlug returns the slug of the Sentry Cron Monitor of the job: its
+// "@sentry.monitor" annotation, or its name. It returns "" if the job opted
+// out of Sentry with "@sentry off".
+func monitorSlug(job *crontab.Job) string {
+	if job.Annotations["sentry"] == "off" {
+		return ""
 	}
+
+	if slug, ok := job.Annotations["sentry.monitor"]; ok {
+		return notify.SentrySlug(slug)
+	}
+
+	return notify.SentrySlug(job.Name)
+}
+
+// mailTo returns the recipients of email notifications for the job, taken
+// from its "@mailto" annotation or the crontab's MAILTO. It returns nil if
+// neither is set, and an empty list if mail was disabled with an empty value.
+func mailTo(cronCtx *crontab.Context, job *crontab.Job) []string {
+	if v, ok := job.Annotations["mailto"]; ok {
+		return notify.ParseMailTo(v)
+	}
+
+	if v, ok := cronCtx.Environ["MAILTO"]; ok {
+		return notify.ParseMailTo(v)
+	}
+
+	return nil
+}
+
+func jobEvent(job *crontab.Job, kind notify.Kind) *notify.Event {
+	return &notify.Event{
+		Kind: kind,
+		Job: notify.Job{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Command:  job.Command,
+			Position: job.Position,
+		},
+	}
+}
+
+// recorder returns the recorder of job metrics, which discards them if none
+// was set.
+func (opts *Options) recorder() metrics.Recorder {
+	if opts.Metrics == nil {
+		return metrics.Multi()
+	}
+
+	return opts.Metrics
 }
@@ -10,16 +10,19 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/notify"
 	"github.com/aptible/supercronic/prometheus_metrics"
+	"github.com/aptible/supercronic/tracing"
 )
 
 var (
 	TEST_CHANNEL_BUFFER_SIZE = 100
-	PROM_METRICS             = prometheus_metrics.NewPrometheusMetrics()
+	PROM_METRICS, _          = prometheus_metrics.NewPrometheusMetrics(prometheus.NewRegistry(), prometheus_metrics.Options{})
 )
 
 type testHook struct {
@@ -71,6 +74,15 @@ var (
 	stderrData               = logrus.Fields{"channel": "stderr"}
 )
 
+func linePartData(part int, continued bool) logrus.Fields {
+	return logrus.Fields{
+		"channel":        "stdout",
+		"line.number":    uint64(0),
+		"line.part":      part,
+		"line.continued": continued,
+	}
+}
+
 var runJobTestCases = []struct {
 	command  string
 	success  bool
@@ -136,11 +148,9 @@ var runJobTestCases = []struct {
 		fmt.Sprintf("python -c 'print(\"a\" * %d * 3)'", READ_BUFFER_SIZE), true, &basicContext,
 		[]*logrus.Entry{
 			{Message: "starting", Level: logrus.InfoLevel, Data: noData},
-			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: stdoutData},
-			{Message: "last line exceeded buffer size, continuing...", Level: logrus.WarnLevel, Data: stdoutData},
-			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: stdoutData},
-			{Message: "last line exceeded buffer size, continuing...", Level: logrus.WarnLevel, Data: stdoutData},
-			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: stdoutData},
+			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: linePartData(0, true)},
+			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: linePartData(1, true)},
+			{Message: strings.Repeat("a", READ_BUFFER_SIZE), Level: logrus.InfoLevel, Data: linePartData(2, false)},
 		},
 	},
 }
@@ -150,7 +160,7 @@ func TestRunJob(t *testing.T) {
 		label := fmt.Sprintf("RunJob(%q)", tt.command)
 		logger, channel := newTestLogger()
 
-		err := runJob(tt.context, tt.command, logger, false)
+		err := runJob(tt.context, tt.command, logger, &jobOutput{})
 		if tt.success {
 			assert.Nil(t, err, label)
 		} else {
@@ -198,7 +208,7 @@ func TestStartJobExitsOnRequest(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	StartJob(&wg, &basicContext, &job, ctx, logger, false, false, &PROM_METRICS)
+	StartJob(&wg, &basicContext, &job, ctx, logger, &Options{Metrics: PROM_METRICS})
 
 	wg.Wait()
 }
@@ -218,7 +228,7 @@ func TestStartJobRunsJob(t *testing.T) {
 
 	logger, channel := newTestLogger()
 
-	StartJob(&wg, &basicContext, &job, ctx, logger, false, false, &PROM_METRICS)
+	StartJob(&wg, &basicContext, &job, ctx, logger, &Options{Metrics: PROM_METRICS})
 
 	select {
 	case entry := <-channel:
@@ -281,12 +291,12 @@ func TestStartFuncWaitsForCompletion(t *testing.T) {
 	ctxStep1, step1Done := context.WithCancel(context.Background())
 	ctxStep2, step2Done := context.WithCancel(context.Background())
 
-	testFn := func(t0 time.Time, jobLogger *logrus.Entry) {
+	testFn := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
 		step1Done()
 		<-ctxStep2.Done()
 	}
 
-	startFunc(&wg, ctxStartFunc, logger, false, expr, time.Local, testFn)
+	startFunc(&wg, ctxStartFunc, logger, false, expr, time.Local, testFn, nil, nil)
 	go func() {
 		wg.Wait()
 		allDone()
@@ -329,12 +339,12 @@ func TestStartFuncDoesNotRunOverlappingJobs(t *testing.T) {
 	ctxStartFunc, cancelStartFunc := context.WithCancel(context.Background())
 	ctxAllDone, allDone := context.WithCancel(context.Background())
 
-	testFn := func(t0 time.Time, jobLogger *logrus.Entry) {
+	testFn := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
 		testChan <- nil
 		<-ctxAllDone.Done()
 	}
 
-	startFunc(&wg, ctxStartFunc, logger, false, expr, time.Local, testFn)
+	startFunc(&wg, ctxStartFunc, logger, false, expr, time.Local, testFn, nil, nil)
 
 	select {
 	case <-testChan:
@@ -368,12 +378,12 @@ func TestStartFuncRunsOverlappingJobs(t *testing.T) {
 	ctxStartFunc, cancelStartFunc := context.WithCancel(context.Background())
 	ctxAllDone, allDone := context.WithCancel(context.Background())
 
-	testFn := func(t0 time.Time, jobLogger *logrus.Entry) {
+	testFn := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
 		testChan <- nil
 		<-ctxAllDone.Done()
 	}
 
-	startFunc(&wg, ctxStartFunc, logger, true, expr, time.Local, testFn)
+	startFunc(&wg, ctxStartFunc, logger, true, expr, time.Local, testFn, nil, nil)
 
 	for i := 0; i < 5; i++ {
 		select {
@@ -406,7 +416,7 @@ func TestStartFuncUsesTz(t *testing.T) {
 
 	it := 0
 
-	testFn := func(t0 time.Time, jobLogger *logrus.Entry) {
+	testFn := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
 		testChan <- t0.Location()
 		it += 1
 
@@ -422,7 +432,7 @@ func TestStartFuncUsesTz(t *testing.T) {
 		}
 	}
 
-	startFunc(&wg, ctxStartFunc, logger, false, expr, loc, testFn)
+	startFunc(&wg, ctxStartFunc, logger, false, expr, loc, testFn, nil, nil)
 
 	for i := 0; i < 5; i++ {
 		select {
@@ -436,3 +446,199 @@ func TestStartFuncUsesTz(t *testing.T) {
 	cancelStartFunc()
 	wg.Wait()
 }
+
+func TestStartFuncReportsNextRun(t *testing.T) {
+	expr := &testExpression{10 * time.Millisecond}
+
+	scheduled := make(chan time.Time, TEST_CHANNEL_BUFFER_SIZE)
+	ran := make(chan time.Time, TEST_CHANNEL_BUFFER_SIZE)
+
+	var wg sync.WaitGroup
+	logger, _ := newTestLogger()
+
+	ctxStartFunc, cancelStartFunc := context.WithCancel(context.Background())
+
+	testFn := func(cronIteration uint64, t0 time.Time, jobLogger *logrus.Entry) {
+		ran <- t0
+	}
+
+	startFunc(&wg, ctxStartFunc, logger, false, expr, time.Local, testFn, nil, func(next time.Time) {
+		scheduled <- next
+	})
+
+	// Each run is announced before it starts
+	for i := 0; i < 3; i++ {
+		select {
+		case next := <-scheduled:
+			assert.Equal(t, next, <-ran)
+		case <-time.After(time.Second):
+			t.Fatalf("timeout")
+		}
+	}
+
+	cancelStartFunc()
+	wg.Wait()
+}
+
+type testNotifier struct {
+	channel chan *notify.Event
+}
+
+func (n *testNotifier) Notify(ctx context.Context, event *notify.Event) error {
+	n.channel <- event
+	return nil
+}
+
+func TestStartJobNotifiesFailure(t *testing.T) {
+	job := crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Expression: &testExpression{100 * time.Millisecond},
+			Schedule:   "always!",
+			Command:    "echo oops; exit 3",
+		},
+		Name:     "failing",
+		Position: 1,
+	}
+
+	logger, channel := newTestLogger()
+
+	notifier := &testNotifier{channel: make(chan *notify.Event, TEST_CHANNEL_BUFFER_SIZE)}
+	dispatcher := notify.NewDispatcher(logger, time.Second)
+	dispatcher.Subscribe(notifier, notify.DefaultKinds)
+
+	opts := &Options{
+		OutputTailLines: 5,
+		Metrics:         PROM_METRICS,
+		Notifier:        dispatcher,
+	}
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	StartJob(&wg, &basicContext, &job, ctx, logger, opts)
+
+	select {
+	case event := <-notifier.channel:
+		assert.Equal(t, notify.Failure, event.Kind)
+		assert.Equal(t, "failing", event.Job.Name)
+		assert.Equal(t, 3, event.ExitCode)
+		assert.Equal(t, []string{"oops"}, event.Output)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for notification")
+	}
+
+	timeout := time.After(3 * time.Second)
+
+	for found := false; !found; {
+		select {
+		case entry := <-channel:
+			if entry.Level == logrus.ErrorLevel {
+				assert.Equal(t, "oops", entry.Data["output"])
+				found = true
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for the error log")
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	dispatcher.Wait()
+}
+
+func TestStartJobCapturesMailOutput(t *testing.T) {
+	job := crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Expression: &testExpression{100 * time.Millisecond},
+			Schedule:   "always!",
+			Command:    "echo a; echo b; echo c; exit 1",
+		},
+		Name: "mail",
+	}
+
+	logger, _ := newTestLogger()
+
+	notifier := &testNotifier{channel: make(chan *notify.Event, TEST_CHANNEL_BUFFER_SIZE)}
+	dispatcher := notify.NewDispatcher(logger, time.Second)
+	dispatcher.Subscribe(notifier, notify.DefaultKinds)
+
+	opts := &Options{
+		OutputTailLines: 1,
+		MailOutputBytes: 1024,
+		Metrics:         PROM_METRICS,
+		Notifier:        dispatcher,
+	}
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	StartJob(&wg, &basicContext, &job, ctx, logger, opts)
+
+	select {
+	case event := <-notifier.channel:
+		assert.Equal(t, []string{"c"}, event.Output)
+		assert.Equal(t, []string{"a", "b", "c"}, event.MailOutput)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for notification")
+	}
+
+	cancel()
+	wg.Wait()
+	dispatcher.Wait()
+}
+
+func TestPingURL(t *testing.T) {
+	job := &crontab.Job{Name: "my job"}
+
+	assert.Equal(t, "", pingURL(&basicContext, job))
+
+	ctx := &crontab.Context{
+		Environ: map[string]string{"SUPERCRONIC_PING_URL": "https://hc.example.com/key/{name}"},
+	}
+	assert.Equal(t, "https://hc.example.com/key/my%20job", pingURL(ctx, job))
+
+	job.Annotations = map[string]string{"ping": "https://hc.example.com/uuid"}
+	assert.Equal(t, "https://hc.example.com/uuid", pingURL(ctx, job))
+}
+
+func TestSpanEnviron(t *testing.T) {
+	assert.Equal(t, &basicContext, spanEnviron(&basicContext, nil))
+
+	// The tracer only connects to the collector when it exports spans
+	tracer, err := tracing.NewTracer(tracing.Config{Endpoint: "http://127.0.0.1:4318"})
+	assert.Nil(t, err)
+	defer tracer.Shutdown(10 * time.Millisecond)
+
+	span := tracer.Start(&crontab.Job{Name: "my job"}, 0)
+	defer span.Finish(0, nil)
+
+	ctx := spanEnviron(&basicContext, span)
+	assert.Regexp(t, "^00-[0-9a-f]{32}-[0-9a-f]{16}-01$", ctx.Environ["TRACEPARENT"])
+	assert.Equal(t, basicContext.Shell, ctx.Shell)
+	assert.NotContains(t, basicContext.Environ, "TRACEPARENT")
+}
+
+func TestMonitorSlug(t *testing.T) {
+	job := &crontab.Job{Name: "My Job"}
+	assert.Equal(t, "my-job", monitorSlug(job))
+
+	job.Annotations = map[string]string{"sentry.monitor": "backups"}
+	assert.Equal(t, "backups", monitorSlug(job))
+
+	job.Annotations = map[string]string{"sentry": "off"}
+	assert.Equal(t, "", monitorSlug(job))
+}
+
+func TestMailTo(t *testing.T) {
+	job := &crontab.Job{}
+
+	assert.Nil(t, mailTo(&basicContext, job))
+
+	ctx := &crontab.Context{
+		Environ: map[string]string{"MAILTO": "a@example.com,b@example.com"},
+	}
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, mailTo(ctx, job))
+
+	job.Annotations = map[string]string{"mailto": ""}
+	assert.Equal(t, []string{}, mailTo(ctx, job))
+}
@@ -0,0 +1,123 @@
+package cron
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/sirupsen/logrus"
+)
+
+type HookEvent string
+
+const (
+	HookBefore    HookEvent = "before"
+	HookAfter     HookEvent = "after"
+	HookOnFailure HookEvent = "on-failure"
+	HookOnSuccess HookEvent = "on-success"
+)
+
+// Hooks are commands run around a job. Jobs can declare their own hooks with
+// "@hook.<event>" annotations, which run after the global ones.
+type Hooks struct {
+	Before    string
+	After     string
+	OnFailure string
+	OnSuccess string
+}
+
+func (h *Hooks) command(event HookEvent) string {
+	switch event {
+	case HookBefore:
+		return h.Before
+	case HookAfter:
+		return h.After
+	case HookOnFailure:
+		return h.OnFailure
+	case HookOnSuccess:
+		return h.OnSuccess
+	}
+
+	return ""
+}
+
+func hookCommands(global *Hooks, job *crontab.Job, event HookEvent) []string {
+	commands := []string{}
+
+	if c := global.command(event); c != "" {
+		commands = append(commands, c)
+	}
+
+	if c := job.Annotations["hook."+string(event)]; c != "" {
+		commands = append(commands, c)
+	}
+
+	return commands
+}
+
+// hookRun describes the job run that hooks are invoked for. Fields that are
+// not known yet (e.g. the exit code in a before hook) are left out of the
+// environment.
+type hookRun struct {
+	iteration uint64
+	finished  bool
+	exitCode  int
+	duration  time.Duration
+	output    string
+}
+
+func hookEnviron(cronCtx *crontab.Context, job *crontab.Job, event HookEvent, run *hookRun) *crontab.Context {
+	environ := make(map[string]string, len(cronCtx.Environ)+8)
+	for k, v := range cronCtx.Environ {
+		environ[k] = v
+	}
+
+	environ["SUPERCRONIC_HOOK"] = string(event)
+	environ["SUPERCRONIC_JOB_NAME"] = job.Name
+	environ["SUPERCRONIC_JOB_SCHEDULE"] = job.Schedule
+	environ["SUPERCRONIC_JOB_COMMAND"] = job.Command
+	environ["SUPERCRONIC_JOB_ITERATION"] = fmt.Sprintf("%d", run.iteration)
+
+	if run.finished {
+		environ["SUPERCRONIC_JOB_EXIT_CODE"] = fmt.Sprintf("%d", run.exitCode)
+		environ["SUPERCRONIC_JOB_DURATION"] = fmt.Sprintf("%.3f", run.duration.Seconds())
+		environ["SUPERCRONIC_JOB_OUTPUT"] = run.output
+	}
+
+	return &crontab.Context{
+		Shell:    cronCtx.Shell,
+		Environ:  environ,
+		Timezone: cronCtx.Timezone,
+	}
+}
+
+// runHooks runs the hooks registered for event. Hook failures are logged and
+// counted, but never change the outcome of the job itself.
+func runHooks(
+	cronCtx *crontab.Context,
+	job *crontab.Job,
+	event HookEvent,
+	run *hookRun,
+	jobLogger *logrus.Entry,
+	opts *Options,
+) {
+	commands := hookCommands(&opts.Hooks, job, event)
+	if len(commands) == 0 {
+		return
+	}
+
+	hookCtx := hookEnviron(cronCtx, job, event, run)
+
+	for _, command := range commands {
+		hookLogger := jobLogger.WithFields(logrus.Fields{
+			"hook":         string(event),
+			"hook.command": command,
+		})
+
+		err := runJob(hookCtx, command, hookLogger, &jobOutput{outputConfig: outputConfig{passthrough: opts.PassthroughLogs}})
+		if err != nil {
+			hookLogger.Errorf("hook failed: %v", err)
+			opts.recorder().HookFailed(job, string(event))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package cron
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+func TestRunHooksOrderAndEnvironment(t *testing.T) {
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Schedule: "* * * * *",
+			Command:  "false",
+		},
+		Name: "backup",
+		Annotations: map[string]string{
+			"hook.after": `echo "job $SUPERCRONIC_HOOK $SUPERCRONIC_JOB_NAME $SUPERCRONIC_JOB_EXIT_CODE $SUPERCRONIC_JOB_OUTPUT"`,
+		},
+	}
+
+	opts := &Options{
+		Hooks:   Hooks{After: `echo "global $SUPERCRONIC_JOB_ITERATION"`},
+		Metrics: PROM_METRICS,
+	}
+
+	logger, channel := newTestLogger()
+
+	run := &hookRun{iteration: 3, finished: true, exitCode: 1, output: "oops"}
+	runHooks(&basicContext, job, HookAfter, run, logger, opts)
+
+	messages := []string{}
+	for len(channel) > 0 {
+		entry := <-channel
+		if entry.Data["channel"] == "stdout" {
+			messages = append(messages, entry.Message)
+		}
+	}
+
+	assert.Equal(t, []string{"global 3", "job after backup 1 oops"}, messages)
+}
+
+func TestRunHooksFailureIsCounted(t *testing.T) {
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Schedule: "hook failure",
+			Command:  "true",
+		},
+		Name: "failing-hook",
+		Annotations: map[string]string{
+			"hook.before": "exit 3",
+		},
+	}
+
+	opts := &Options{Metrics: PROM_METRICS}
+
+	logger, channel := newTestLogger()
+
+	runHooks(&basicContext, job, HookBefore, &hookRun{}, logger, opts)
+
+	var failure *logrus.Entry
+	for len(channel) > 0 {
+		entry := <-channel
+		if entry.Level == logrus.ErrorLevel {
+			failure = entry
+		}
+	}
+
+	if assert.NotNil(t, failure) {
+		assert.Regexp(t, "hook failed: .*exit status 3", failure.Message)
+		assert.Equal(t, "before", failure.Data["hook"])
+	}
+
+	labels := PROM_METRICS.JobLabels(job)
+	labels["hook"] = "before"
+	assert.Equal(t, 1.0, testutil.ToFloat64(PROM_METRICS.CronsHookFailCounter.With(labels)))
+}
+
+func TestStartJobRunsHooks(t *testing.T) {
+	job := crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Expression: &testExpression{100 * time.Millisecond},
+			Schedule:   "always!",
+			Command:    "echo oops; exit 2",
+		},
+		Position: 1,
+	}
+
+	opts := &Options{
+		Hooks: Hooks{
+			OnFailure: "echo failed $SUPERCRONIC_JOB_EXIT_CODE $SUPERCRONIC_JOB_OUTPUT",
+			OnSuccess: "echo succeeded",
+		},
+		OutputTailLines: 20,
+		Metrics:         PROM_METRICS,
+	}
+
+	logger, channel := newTestLogger()
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	StartJob(&wg, &basicContext, &job, ctx, logger, opts)
+
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case entry := <-channel:
+			assert.NotEqual(t, "succeeded", entry.Message)
+			if entry.Message == "failed 2 oops" {
+				cancel()
+				wg.Wait()
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for failure hook")
+		}
+	}
+}
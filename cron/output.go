@@ -0,0 +1,479 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/sirupsen/logrus"
+)
+
+type OutputFormat string
+
+const (
+	OutputText OutputFormat = "text"
+	OutputJSON OutputFormat = "json"
+)
+
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch OutputFormat(s) {
+	case OutputText, OutputJSON:
+		return OutputFormat(s), nil
+	}
+
+	return "", fmt.Errorf("unknown output format: %q (expected text or json)", s)
+}
+
+type LongLinePolicy string
+
+const (
+	// LongLinesTruncate reassembles lines up to the maximum line length,
+	// and truncates them past that.
+	LongLinesTruncate LongLinePolicy = "truncate"
+	// LongLinesSplit logs lines longer than the maximum line length as
+	// several entries, linked by their "line.number" field.
+	LongLinesSplit LongLinePolicy = "split"
+)
+
+func ParseLongLinePolicy(s string) (LongLinePolicy, error) {
+	switch LongLinePolicy(s) {
+	case LongLinesTruncate, LongLinesSplit:
+		return LongLinePolicy(s), nil
+	}
+
+	return "", fmt.Errorf("unknown long line policy: %q (expected truncate or split)", s)
+}
+
+// ParseOutputLevel parses the level job output is logged at. Fatal and panic
+// levels are rejected, since logging at those levels would stop Supercronic.
+func ParseOutputLevel(s string) (logrus.Level, error) {
+	level, err := logrus.ParseLevel(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if level < logrus.ErrorLevel {
+		return 0, fmt.Errorf("job output cannot be logged at level %s", level)
+	}
+
+	return level, nil
+}
+
+// LevelRule raises the level of output lines matching Pattern to Level.
+type LevelRule struct {
+	Level   logrus.Level
+	Pattern *regexp.Regexp
+}
+
+// ParseLevelRule parses a rule of the form "LEVEL=REGEX".
+func ParseLevelRule(s string) (LevelRule, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return LevelRule{}, fmt.Errorf("invalid level rule: %q (expected LEVEL=REGEX)", s)
+	}
+
+	level, err := ParseOutputLevel(parts[0])
+	if err != nil {
+		return LevelRule{}, err
+	}
+
+	pattern, err := regexp.Compile(parts[1])
+	if err != nil {
+		return LevelRule{}, err
+	}
+
+	return LevelRule{Level: level, Pattern: pattern}, nil
+}
+
+// outputConfig describes how the output of a job is handled. It is resolved
+// once per job from the global Options and the job's annotations.
+type outputConfig struct {
+	passthrough bool
+	tailLines   int
+	tailBytes   int
+	mailBytes   int
+	format      OutputFormat
+	jsonPrefix  string
+	levels      map[string]logrus.Level
+	levelRules  []LevelRule
+	longLines   LongLinePolicy
+	maxLine     int
+	rateLimit   float64
+	burst       int
+	byteLimit   int64
+	files       *jobOutputFiles
+	fileOnly    bool
+}
+
+func newOutputConfig(opts *Options, job *crontab.Job, logger *logrus.Entry) outputConfig {
+	config := outputConfig{
+		passthrough: opts.PassthroughLogs,
+		tailLines:   opts.OutputTailLines,
+		tailBytes:   opts.OutputTailBytes,
+		mailBytes:   opts.MailOutputBytes,
+		format:      opts.OutputFormat,
+		jsonPrefix:  opts.OutputJSONPrefix,
+		levels:      make(map[string]logrus.Level),
+		levelRules:  opts.LevelRules,
+		longLines:   opts.LongLines,
+		maxLine:     opts.MaxLineLength,
+		rateLimit:   opts.OutputRateLimit,
+		burst:       opts.OutputBurst,
+		byteLimit:   opts.OutputByteLimit,
+		fileOnly:    opts.OutputFiles.Only,
+	}
+
+	for channel, level := range opts.ChannelLevels {
+		config.levels[channel] = level
+	}
+
+	if v, ok := job.Annotations["output.format"]; ok {
+		format, err := ParseOutputFormat(v)
+		if err != nil {
+			logger.Warnf("ignoring @output.format annotation: %v", err)
+		} else {
+			config.format = format
+		}
+	}
+
+	if v, ok := job.Annotations["output.long-lines"]; ok {
+		policy, err := ParseLongLinePolicy(v)
+		if err != nil {
+			logger.Warnf("ignoring @output.long-lines annotation: %v", err)
+		} else {
+			config.longLines = policy
+		}
+	}
+
+	if v, ok := job.Annotations["output.max-line-length"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			logger.Warnf("ignoring @output.max-line-length annotation: invalid length %q", v)
+		} else {
+			config.maxLine = n
+		}
+	}
+
+	if v, ok := job.Annotations["output.rate-limit"]; ok {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n < 0 {
+			logger.Warnf("ignoring @output.rate-limit annotation: invalid rate %q", v)
+		} else {
+			config.rateLimit = n
+		}
+	}
+
+	if v, ok := job.Annotations["output.burst"]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			logger.Warnf("ignoring @output.burst annotation: invalid burst %q", v)
+		} else {
+			config.burst = n
+		}
+	}
+
+	if v, ok := job.Annotations["output.byte-limit"]; ok {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			logger.Warnf("ignoring @output.byte-limit annotation: invalid limit %q", v)
+		} else {
+			config.byteLimit = n
+		}
+	}
+
+	files := opts.OutputFiles
+	if v, ok := job.Annotations["output.file"]; ok {
+		mode, err := ParseOutputFileMode(v)
+		if err != nil {
+			logger.Warnf("ignoring @output.file annotation: %v", err)
+		} else {
+			files.Mode = mode
+		}
+	}
+	config.files = newJobOutputFiles(files, job.Name, logger)
+
+	for _, channel := range []string{"stdout", "stderr"} {
+		if v, ok := job.Annotations[channel+".level"]; ok {
+			level, err := ParseOutputLevel(v)
+			if err != nil {
+				logger.Warnf("ignoring @%s.level annotation: %v", channel, err)
+			} else {
+				config.levels[channel] = level
+			}
+		}
+	}
+
+	for _, name := range []string{"error", "warn", "info"} {
+		if v, ok := job.Annotations["level."+name]; ok {
+			level, _ := logrus.ParseLevel(name)
+
+			pattern, err := regexp.Compile(v)
+			if err != nil {
+				logger.Warnf("ignoring @level.%s annotation: %v", name, err)
+				continue
+			}
+
+			// Copy the global rules so that jobs don't share
+			// their own rules
+			rules := make([]LevelRule, 0, len(config.levelRules)+1)
+			rules = append(rules, config.levelRules...)
+			config.levelRules = append(rules, LevelRule{Level: level, Pattern: pattern})
+		}
+	}
+
+	return config
+}
+
+// level returns the level line should be logged at on channel: the level of
+// the channel (info by default), raised by any matching rule.
+func (c *outputConfig) level(channel string, line string) logrus.Level {
+	return c.raiseLevel(c.channelLevel(channel), line)
+}
+
+// channelLevel returns the level lines of channel are logged at, before any
+// rule applies.
+func (c *outputConfig) channelLevel(channel string) logrus.Level {
+	level, ok := c.levels[channel]
+	if !ok {
+		level = logrus.InfoLevel
+	}
+
+	return level
+}
+
+// raiseLevel raises level to that of the rules matching line.
+func (c *outputConfig) raiseLevel(level logrus.Level, line string) logrus.Level {
+	for _, rule := range c.levelRules {
+		if rule.Level < level && rule.Pattern.MatchString(line) {
+			level = rule.Level
+		}
+	}
+
+	return level
+}
+
+// jobOutput handles the output of a single run.
+type jobOutput struct {
+	outputConfig
+	tail    *outputTail
+	mail    *outputCapture
+	limiter *outputLimiter
+	file    *outputFile
+}
+
+func (c outputConfig) newRun() *jobOutput {
+	return &jobOutput{
+		outputConfig: c,
+		tail:         newOutputTail(c.tailLines, c.tailBytes),
+		mail:         newOutputCapture(c.mailBytes),
+		limiter:      newOutputLimiter(c.rateLimit, c.burst, c.byteLimit),
+		file:         c.files.open(),
+	}
+}
+
+// droppedLines returns the number of lines that were not logged on each
+// channel because of the output limits.
+func (o *jobOutput) droppedLines() map[string]uint64 {
+	return o.limiter.droppedLines()
+}
+
+// logLine logs a line the job wrote to channel.
+func (o *jobOutput) logLine(readerLogger *logrus.Entry, channel string, line string) {
+	o.tail.add(line)
+	o.mail.add(line)
+
+	// The raw output is already in the output file
+	if o.fileOnly && o.file != nil {
+		return
+	}
+
+	if !o.limiter.allow(channel, len(line)) {
+		return
+	}
+
+	if o.format == OutputJSON {
+		// The level of the line takes precedence over that of the
+		// channel, and rules can only raise it
+		if entry, jsonLevel, ok := parseJSONLine(readerLogger, line, o.jsonPrefix, o.channelLevel(channel)); ok {
+			entry.Log(o.raiseLevel(jsonLevel, line), entry.Message)
+			return
+		}
+	}
+
+	readerLogger.Log(o.level(channel, line), line)
+}
+
+// lineAssembler reassembles the chunks returned by bufio.Reader.ReadLine
+// into lines, applying the long line policy of the job.
+type lineAssembler struct {
+	output  *jobOutput
+	logger  *logrus.Entry
+	channel string
+
+	buf     []byte
+	dropped int
+	number  uint64
+	part    int
+}
+
+func (o *jobOutput) newLineAssembler(readerLogger *logrus.Entry, channel string) *lineAssembler {
+	return &lineAssembler{output: o, logger: readerLogger, channel: channel}
+}
+
+func (a *lineAssembler) maxLine() int {
+	if a.output.maxLine > 0 {
+		return a.output.maxLine
+	}
+
+	return READ_BUFFER_SIZE
+}
+
+// write adds chunk to the current line. isPrefix indicates that the line
+// continues in the next chunk.
+func (a *lineAssembler) write(chunk []byte, isPrefix bool) {
+	max := a.maxLine()
+
+	if a.output.longLines == LongLinesTruncate {
+		if room := max - len(a.buf); room < len(chunk) {
+			a.dropped += len(chunk) - room
+			chunk = chunk[:room]
+		}
+		a.buf = append(a.buf, chunk...)
+	} else {
+		a.buf = append(a.buf, chunk...)
+
+		for len(a.buf) > max {
+			a.emit(a.buf[:max], true)
+			a.buf = a.buf[max:]
+		}
+	}
+
+	if !isPrefix {
+		a.end()
+	}
+}
+
+// flush logs the line that was still being read when the output ended, if
+// any.
+func (a *lineAssembler) flush() {
+	if len(a.buf) == 0 && a.dropped == 0 && a.part == 0 {
+		return
+	}
+
+	a.end()
+}
+
+// end logs the current line, even if it is empty.
+func (a *lineAssembler) end() {
+	line := a.buf
+	if a.dropped > 0 {
+		line = append(line, fmt.Sprintf("... [truncated %d bytes]", a.dropped)...)
+	}
+
+	a.emit(line, false)
+
+	a.buf = a.buf[:0]
+	a.dropped = 0
+	a.part = 0
+	a.number++
+}
+
+func (a *lineAssembler) emit(line []byte, continued bool) {
+	logger := a.logger
+
+	if continued || a.part > 0 {
+		logger = logger.WithFields(logrus.Fields{
+			"line.number":    a.number,
+			"line.part":      a.part,
+			"line.continued": continued,
+		})
+		a.part++
+	}
+
+	a.output.logLine(logger, a.channel, string(line))
+}
+
+var (
+	jsonMessageKeys = []string{"msg", "message"}
+	jsonLevelKeys   = []string{"level", "lvl", "severity"}
+)
+
+// parseJSONLine merges the keys of a line holding a JSON object into the
+// fields of readerLogger, under prefix. The message is taken from a "msg" or
+// "message" key, and the level from a "level" key (defaulting to
+// defaultLevel), capped at error level so that a job cannot make Supercronic
+// exit or panic.
+// isOwnField returns whether name is a field set by supercronic itself on the
+// entries of a job.
+func isOwnField(readerLogger *logrus.Entry, name string) bool {
+	if _, ok := readerLogger.Data[name]; ok {
+		return true
+	}
+
+	return strings.HasPrefix(name, "job.") || name == "channel" || name == "iteration"
+}
+
+func parseJSONLine(readerLogger *logrus.Entry, line string, prefix string, defaultLevel logrus.Level) (*logrus.Entry, logrus.Level, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return nil, 0, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewBufferString(trimmed))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil || decoder.More() {
+		return nil, 0, false
+	}
+
+	level := defaultLevel
+	message := ""
+
+	for _, k := range jsonLevelKeys {
+		if v, ok := object[k].(string); ok {
+			if l, err := logrus.ParseLevel(strings.ToLower(v)); err == nil {
+				level = l
+				delete(object, k)
+				break
+			}
+		}
+	}
+
+	if level < logrus.ErrorLevel {
+		level = logrus.ErrorLevel
+	}
+
+	for _, k := range jsonMessageKeys {
+		if v, ok := object[k].(string); ok {
+			message = v
+			delete(object, k)
+			break
+		}
+	}
+
+	fields := make(logrus.Fields, len(object))
+	for k, v := range object {
+		name := prefix + k
+
+		// Jobs must not override the fields that identify them (e.g.
+		// with an empty prefix): these are moved under output. instead
+		if isOwnField(readerLogger, name) {
+			name = "output." + k
+			if isOwnField(readerLogger, name) {
+				continue
+			}
+		}
+
+		fields[name] = v
+	}
+
+	entry := readerLogger.WithFields(fields)
+	entry.Message = message
+
+	return entry, level, true
+}
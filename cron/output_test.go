@@ -0,0 +1,306 @@
+package cron
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+func TestJobOutputParsesJSONLines(t *testing.T) {
+	logger, channel := newTestLogger()
+	logger = logger.WithField("channel", "stdout")
+
+	output := outputConfig{format: OutputJSON, jsonPrefix: "output.", tailLines: 10}.newRun()
+
+	output.logLine(logger, "stdout", `{"msg": "hello", "level": "warning", "user": {"id": 42}, "count": 3}`)
+	output.logLine(logger, "stdout", `not json`)
+	output.logLine(logger, "stdout", `{"broken": `)
+	output.logLine(logger, "stdout", `{"a": 1} {"b": 2}`)
+	output.logLine(logger, "stdout", `{"message": "boom", "level": "fatal"}`)
+
+	entry := <-channel
+	assert.Equal(t, "hello", entry.Message)
+	assert.Equal(t, logrus.WarnLevel, entry.Level)
+	assert.Equal(t, logrus.Fields{
+		"channel":      "stdout",
+		"output.user":  map[string]interface{}{"id": json.Number("42")},
+		"output.count": json.Number("3"),
+	}, entry.Data)
+
+	for _, msg := range []string{"not json", `{"broken": `, `{"a": 1} {"b": 2}`} {
+		entry = <-channel
+		assert.Equal(t, msg, entry.Message)
+		assert.Equal(t, logrus.InfoLevel, entry.Level)
+		assert.Equal(t, logrus.Fields{"channel": "stdout"}, entry.Data)
+	}
+
+	entry = <-channel
+	assert.Equal(t, "boom", entry.Message)
+	assert.Equal(t, logrus.ErrorLevel, entry.Level)
+
+	assert.Len(t, output.tail.lines(), 5)
+}
+
+func TestJobOutputJSONCannotOverrideOwnFields(t *testing.T) {
+	logger, channel := newTestLogger()
+	logger = logger.WithFields(logrus.Fields{
+		"channel":   "stdout",
+		"iteration": 3,
+		"job.name":  "backup",
+		"hook":      "after",
+	})
+
+	output := outputConfig{format: OutputJSON, jsonPrefix: ""}.newRun()
+
+	output.logLine(logger, "stdout", `{"msg": "hello", "job.name": "other", "job.schedule": "@daily", "channel": "stderr", "iteration": 9, "hook": "before", "user": "bob"}`)
+
+	entry := <-channel
+	assert.Equal(t, "hello", entry.Message)
+	assert.Equal(t, logrus.Fields{
+		"channel":             "stdout",
+		"iteration":           3,
+		"job.name":            "backup",
+		"hook":                "after",
+		"output.job.name":     "other",
+		"output.job.schedule": "@daily",
+		"output.channel":      "stderr",
+		"output.iteration":    json.Number("9"),
+		"output.hook":         "before",
+		"user":                "bob",
+	}, entry.Data)
+}
+
+func TestJobOutputJSONLevelOverridesChannelLevel(t *testing.T) {
+	logger, channel := newTestLogger()
+
+	output := outputConfig{
+		format: OutputJSON,
+		levels: map[string]logrus.Level{"stdout": logrus.InfoLevel, "stderr": logrus.WarnLevel},
+		levelRules: []LevelRule{
+			{Level: logrus.ErrorLevel, Pattern: regexp.MustCompile("FATAL")},
+		},
+	}.newRun()
+
+	output.logLine(logger, "stdout", `{"msg": "details", "level": "debug"}`)
+	output.logLine(logger, "stderr", `{"msg": "fine", "level": "info"}`)
+	output.logLine(logger, "stderr", `{"msg": "no level"}`)
+	output.logLine(logger, "stdout", `{"msg": "FATAL", "level": "debug"}`)
+
+	for _, expected := range []logrus.Level{logrus.DebugLevel, logrus.InfoLevel, logrus.WarnLevel, logrus.ErrorLevel} {
+		entry := <-channel
+		assert.Equal(t, expected, entry.Level, entry.Message)
+	}
+}
+
+func TestJobOutputTextIgnoresJSON(t *testing.T) {
+	logger, channel := newTestLogger()
+
+	output := outputConfig{format: OutputText}.newRun()
+	output.logLine(logger, "stdout", `{"msg": "hello"}`)
+
+	entry := <-channel
+	assert.Equal(t, `{"msg": "hello"}`, entry.Message)
+}
+
+func TestNewOutputConfigAnnotations(t *testing.T) {
+	logger, channel := newTestLogger()
+
+	opts := &Options{OutputFormat: OutputText}
+
+	job := &crontab.Job{Annotations: map[string]string{"output.format": "json"}}
+	assert.Equal(t, OutputJSON, newOutputConfig(opts, job, logger).format)
+
+	job = &crontab.Job{Annotations: map[string]string{"output.format": "yaml"}}
+	assert.Equal(t, OutputText, newOutputConfig(opts, job, logger).format)
+
+	entry := <-channel
+	assert.Equal(t, logrus.WarnLevel, entry.Level)
+}
+
+func TestJobOutputLevels(t *testing.T) {
+	logger, channel := newTestLogger()
+
+	rule, err := ParseLevelRule("error=ERROR|Traceback")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	opts := &Options{
+		ChannelLevels: map[string]logrus.Level{"stderr": logrus.WarnLevel},
+		LevelRules:    []LevelRule{rule},
+	}
+
+	job := &crontab.Job{Annotations: map[string]string{
+		"stdout.level": "debug",
+		"level.warn":   "^WARN",
+	}}
+
+	output := newOutputConfig(opts, job, logger).newRun()
+
+	for _, l := range []struct {
+		channel string
+		line    string
+		level   logrus.Level
+	}{
+		{"stdout", "hello", logrus.DebugLevel},
+		{"stderr", "hello", logrus.WarnLevel},
+		{"stdout", "Traceback (most recent call last):", logrus.ErrorLevel},
+		{"stderr", "ERROR: oops", logrus.ErrorLevel},
+		{"stdout", "WARN: careful", logrus.WarnLevel},
+	} {
+		output.logLine(logger, l.channel, l.line)
+
+		entry := <-channel
+		assert.Equal(t, l.line, entry.Message)
+		assert.Equal(t, l.level, entry.Level, l.line)
+	}
+
+	// Rules from annotations must not leak to other jobs
+	assert.Len(t, opts.LevelRules, 1)
+}
+
+func TestParseLevelRule(t *testing.T) {
+	_, err := ParseLevelRule("fatal=oops")
+	assert.NotNil(t, err)
+
+	_, err = ParseLevelRule("error")
+	assert.NotNil(t, err)
+
+	_, err = ParseLevelRule("error=(")
+	assert.NotNil(t, err)
+
+	rule, err := ParseLevelRule("warn=a=b")
+	if assert.Nil(t, err) {
+		assert.Equal(t, logrus.WarnLevel, rule.Level)
+		assert.Equal(t, "a=b", rule.Pattern.String())
+	}
+}
+
+func drainForTest(config outputConfig, data []byte) []*logrus.Entry {
+	logger, channel := newTestLogger()
+
+	var wg sync.WaitGroup
+	startReaderDrain(&wg, logger, "stdout", io.NopCloser(bytes.NewReader(data)), config.newRun())
+	wg.Wait()
+
+	entries := []*logrus.Entry{}
+	for len(channel) > 0 {
+		entries = append(entries, <-channel)
+	}
+
+	return entries
+}
+
+func TestLongLinesTruncate(t *testing.T) {
+	config := outputConfig{longLines: LongLinesTruncate, maxLine: 10}
+
+	data := strings.Repeat("a", 100*1024) + "\nshort\n" + strings.Repeat("b", 15)
+	entries := drainForTest(config, []byte(data))
+
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "aaaaaaaaaa... [truncated 102390 bytes]", entries[0].Message)
+		assert.Equal(t, "short", entries[1].Message)
+		assert.Equal(t, "bbbbbbbbbb... [truncated 5 bytes]", entries[2].Message)
+
+		for _, e := range entries {
+			assert.NotContains(t, e.Data, "line.part")
+		}
+	}
+}
+
+func TestLongLinesSplit(t *testing.T) {
+	config := outputConfig{longLines: LongLinesSplit, maxLine: 4}
+
+	entries := drainForTest(config, []byte("abcdefghij\nxyz\n"))
+
+	if assert.Len(t, entries, 4) {
+		for i, expected := range []struct {
+			message   string
+			part      int
+			continued bool
+		}{
+			{"abcd", 0, true},
+			{"efgh", 1, true},
+			{"ij", 2, false},
+		} {
+			assert.Equal(t, expected.message, entries[i].Message)
+			assert.Equal(t, uint64(0), entries[i].Data["line.number"])
+			assert.Equal(t, expected.part, entries[i].Data["line.part"])
+			assert.Equal(t, expected.continued, entries[i].Data["line.continued"])
+		}
+
+		assert.Equal(t, "xyz", entries[3].Message)
+		assert.NotContains(t, entries[3].Data, "line.part")
+	}
+}
+
+func TestLongLinesSplitExactLength(t *testing.T) {
+	config := outputConfig{longLines: LongLinesSplit, maxLine: 4}
+
+	entries := drainForTest(config, []byte("abcd\nefgh"))
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "abcd", entries[0].Message)
+		assert.Equal(t, "efgh", entries[1].Message)
+		assert.NotContains(t, entries[0].Data, "line.part")
+	}
+}
+
+func TestEmptyLinesAreKept(t *testing.T) {
+	for _, policy := range []LongLinePolicy{LongLinesTruncate, LongLinesSplit} {
+		config := outputConfig{longLines: policy, tailLines: 10}
+		output := config.newRun()
+
+		logger, channel := newTestLogger()
+
+		var wg sync.WaitGroup
+		startReaderDrain(&wg, logger, "stdout", io.NopCloser(strings.NewReader("a\n\n\nb\n")), output)
+		wg.Wait()
+
+		messages := []string{}
+		for len(channel) > 0 {
+			messages = append(messages, (<-channel).Message)
+		}
+
+		assert.Equal(t, []string{"a", "", "", "b"}, messages, policy)
+		assert.Equal(t, []string{"a", "", "", "b"}, output.tail.lines(), policy)
+	}
+}
+
+func TestBinaryOutput(t *testing.T) {
+	data := make([]byte, 256*1024)
+	for i := range data {
+		// No newlines, plenty of NUL and invalid UTF-8 bytes
+		data[i] = byte(i%255) + 1
+		if data[i] == '\n' {
+			data[i] = 0
+		}
+	}
+
+	for _, policy := range []LongLinePolicy{LongLinesTruncate, LongLinesSplit} {
+		config := outputConfig{longLines: policy, maxLine: 64 * 1024, tailLines: 10, tailBytes: 1024}
+		entries := drainForTest(config, data)
+
+		total := 0
+		for _, e := range entries {
+			assert.True(t, len(e.Message) <= 64*1024+64, string(policy))
+			total += len(e.Message)
+		}
+
+		if policy == LongLinesSplit {
+			assert.Len(t, entries, 4)
+			assert.Equal(t, len(data), total)
+		} else {
+			assert.Len(t, entries, 1)
+		}
+	}
+}
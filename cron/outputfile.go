@@ -0,0 +1,436 @@
+package cron
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type OutputFileMode string
+
+const (
+	OutputFileOff     OutputFileMode = "off"
+	OutputFilePerRun  OutputFileMode = "run"
+	OutputFileRolling OutputFileMode = "rolling"
+)
+
+func ParseOutputFileMode(s string) (OutputFileMode, error) {
+	switch OutputFileMode(s) {
+	case OutputFileOff, OutputFilePerRun, OutputFileRolling:
+		return OutputFileMode(s), nil
+	default:
+		return "", fmt.Errorf("unknown output file mode: %q", s)
+	}
+}
+
+// OutputFiles configures writing the raw output of jobs to files under
+// Dir/<job-name>/. In "run" mode, each run is written to its own
+// <timestamp>.log file. In "rolling" mode, all runs are appended to
+// output.log, which is rotated once it grows over MaxSize bytes or gets older
+// than MaxAge. Retain limits the number of finished run files or rotated
+// files that are kept, and Compress gzips them.
+type OutputFiles struct {
+	Dir      string
+	Mode     OutputFileMode
+	Only     bool
+	MaxSize  int64
+	MaxAge   time.Duration
+	Retain   int
+	Compress bool
+}
+
+const (
+	outputFileTimeFormat = "2006-01-02T15-04-05.000Z"
+	rollingFileName      = "output.log"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// jobOutputFiles holds the output files of a job. It is shared by all the
+// runs of the job, so that runs append to the same rolling file.
+type jobOutputFiles struct {
+	OutputFiles
+	dir    string
+	logger *logrus.Entry
+
+	mu      sync.Mutex
+	rolling *os.File
+	size    int64
+	opened  time.Time
+	users   int
+	now     func() time.Time
+
+	// The run files that are still being written, which prune keeps
+	writingMu sync.Mutex
+	writing   map[string]bool
+}
+
+func newJobOutputFiles(config OutputFiles, name string, logger *logrus.Entry) *jobOutputFiles {
+	if config.Dir == "" || config.Mode == "" || config.Mode == OutputFileOff {
+		return nil
+	}
+
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), ".")
+	if name == "" {
+		name = "_"
+	}
+
+	return &jobOutputFiles{
+		OutputFiles: config,
+		dir:         filepath.Join(config.Dir, name),
+		logger:      logger,
+		now:         time.Now,
+		writing:     map[string]bool{},
+	}
+}
+
+// outputFile receives the raw output of a single run. Write never fails:
+// errors are logged once, so that a full disk never breaks a job.
+type outputFile struct {
+	files *jobOutputFiles
+	path  string
+
+	mu     sync.Mutex
+	file   *os.File
+	failed bool
+}
+
+// open returns the file the output of a new run should be written to, or
+// nil if the output cannot be written to a file.
+func (f *jobOutputFiles) open() *outputFile {
+	if f == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		f.logger.Errorf("failed to create output directory: %v", err)
+		return nil
+	}
+
+	if f.Mode == OutputFileRolling {
+		return f.openRolling()
+	}
+
+	base := f.now().UTC().Format(outputFileTimeFormat)
+
+	for i := 0; ; i++ {
+		path := f.timestampedPath("", base, i)
+
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			f.logger.Errorf("failed to open output file: %v", err)
+			return nil
+		}
+
+		f.writingMu.Lock()
+		f.writing[filepath.Base(path)] = true
+		f.writingMu.Unlock()
+
+		return &outputFile{files: f, path: path, file: file}
+	}
+}
+
+// timestampedPath returns the path of a finished run file or a rotated file.
+// Files created at the same time get a "_N" suffix (see outputFileOrder).
+func (f *jobOutputFiles) timestampedPath(prefix string, timestamp string, i int) string {
+	if i == 0 {
+		return filepath.Join(f.dir, prefix+timestamp+".log")
+	}
+
+	return filepath.Join(f.dir, fmt.Sprintf("%s%s_%d.log", prefix, timestamp, i))
+}
+
+// unusedRotatedPath returns the path the rolling file should be renamed to
+// when it is rotated at t.
+func (f *jobOutputFiles) unusedRotatedPath(t time.Time) string {
+	timestamp := t.UTC().Format(outputFileTimeFormat)
+
+	for i := 0; ; i++ {
+		path := f.timestampedPath("output-", timestamp, i)
+
+		if fileExists(path) || fileExists(path+".gz") {
+			continue
+		}
+
+		return path
+	}
+}
+
+func (f *jobOutputFiles) openRolling() *outputFile {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := filepath.Join(f.dir, rollingFileName)
+
+	if f.rolling == nil {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			f.logger.Errorf("failed to open output file: %v", err)
+			return nil
+		}
+
+		f.rolling = file
+		f.size = 0
+		f.opened = f.now()
+
+		// A file left by a previous process is as old as its
+		// last write, as far as we can tell.
+		if info, err := file.Stat(); err == nil && info.Size() > 0 {
+			f.size = info.Size()
+			f.opened = info.ModTime()
+		}
+	}
+
+	f.users++
+
+	return &outputFile{files: f, path: path}
+}
+
+func (o *outputFile) Write(p []byte) (int, error) {
+	if o == nil {
+		return len(p), nil
+	}
+
+	if o.files.Mode == OutputFileRolling {
+		o.files.writeRolling(p)
+		return len(p), nil
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.failed {
+		return len(p), nil
+	}
+
+	if _, err := o.file.Write(p); err != nil {
+		o.files.logger.Errorf("failed to write output file: %v", err)
+		o.failed = true
+	}
+
+	return len(p), nil
+}
+
+func (f *jobOutputFiles) writeRolling(p []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rolling == nil {
+		return
+	}
+
+	now := f.now()
+	if f.size > 0 && ((f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize) || (f.MaxAge > 0 && now.Sub(f.opened) >= f.MaxAge)) {
+		f.rotate(now)
+		if f.rolling == nil {
+			return
+		}
+	}
+
+	n, err := f.rolling.Write(p)
+	f.size += int64(n)
+	if err != nil {
+		f.logger.Errorf("failed to write output file: %v", err)
+	}
+}
+
+// rotate renames the rolling file and opens a new one. It must be called
+// with f.mu held.
+func (f *jobOutputFiles) rotate(now time.Time) {
+	path := filepath.Join(f.dir, rollingFileName)
+	rotated := f.unusedRotatedPath(now)
+
+	if err := f.rolling.Close(); err != nil {
+		f.logger.Errorf("failed to close output file: %v", err)
+	}
+	f.rolling = nil
+
+	if err := os.Rename(path, rotated); err != nil {
+		f.logger.Errorf("failed to rotate output file: %v", err)
+	} else {
+		f.finish(rotated)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		f.logger.Errorf("failed to open output file: %v", err)
+		return
+	}
+
+	f.rolling = file
+	f.size = 0
+	f.opened = now
+}
+
+// Close finishes writing the output of the run, and returns the path of the
+// file it was written to.
+func (o *outputFile) Close() string {
+	if o == nil {
+		return ""
+	}
+
+	if o.files.Mode == OutputFileRolling {
+		f := o.files
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.users--
+		if f.users == 0 && f.rolling != nil {
+			if err := f.rolling.Close(); err != nil {
+				f.logger.Errorf("failed to close output file: %v", err)
+			}
+			f.rolling = nil
+		}
+
+		return o.path
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.file.Close(); err != nil {
+		o.files.logger.Errorf("failed to close output file: %v", err)
+	}
+
+	o.files.writingMu.Lock()
+	delete(o.files.writing, filepath.Base(o.path))
+	o.files.writingMu.Unlock()
+
+	return o.files.finish(o.path)
+}
+
+// finish compresses a finished run file or a rotated file if requested,
+// removes the files over the retention count, and returns the final path of
+// the file.
+func (f *jobOutputFiles) finish(path string) string {
+	if f.Compress {
+		if err := compressFile(path); err != nil {
+			f.logger.Errorf("failed to compress output file: %v", err)
+		} else {
+			path += ".gz"
+		}
+	}
+
+	if f.Retain > 0 {
+		f.prune()
+	}
+
+	return path
+}
+
+// prune removes the oldest finished run files or rotated files, keeping the
+// last f.Retain ones. The files of runs that are still being written are
+// neither removed nor counted.
+func (f *jobOutputFiles) prune() {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		f.logger.Errorf("failed to list output files: %v", err)
+		return
+	}
+
+	prefix := ""
+	if f.Mode == OutputFileRolling {
+		prefix = "output-"
+	}
+
+	f.writingMu.Lock()
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == rollingFileName || !strings.HasPrefix(name, prefix) || f.writing[name] {
+			continue
+		}
+
+		if strings.HasSuffix(name, ".log") || strings.HasSuffix(name, ".log.gz") {
+			names = append(names, name)
+		}
+	}
+	f.writingMu.Unlock()
+
+	if len(names) <= f.Retain {
+		return
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		ti, ni := outputFileOrder(names[i], prefix)
+		tj, nj := outputFileOrder(names[j], prefix)
+		if ti != tj {
+			return ti < tj
+		}
+		return ni < nj
+	})
+
+	for _, name := range names[:len(names)-f.Retain] {
+		if err := os.Remove(filepath.Join(f.dir, name)); err != nil {
+			f.logger.Errorf("failed to remove output file: %v", err)
+		}
+	}
+}
+
+// outputFileOrder returns the timestamp and the "_N" suffix of the name of a
+// run file or a rotated file, which order files chronologically. Timestamps
+// sort as strings, but suffixes must be compared as numbers ("_10" comes
+// after "_2").
+func outputFileOrder(name string, prefix string) (string, int) {
+	name = strings.TrimPrefix(name, prefix)
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".log")
+
+	timestamp, suffix, _ := strings.Cut(name, "_")
+	n, _ := strconv.Atoi(suffix)
+
+	return timestamp, n
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := gzip.NewWriter(out)
+
+	if _, err := io.Copy(w, in); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		out.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,218 @@
+package cron
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+func readOutputFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if !assert.Nil(t, err) {
+		return ""
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if !assert.Nil(t, err) {
+			return ""
+		}
+		r = gz
+	}
+
+	b, err := io.ReadAll(r)
+	assert.Nil(t, err)
+
+	return string(b)
+}
+
+func listOutputFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	return names
+}
+
+func TestOutputFilesDisabled(t *testing.T) {
+	logger, _ := newTestLogger()
+
+	assert.Nil(t, newJobOutputFiles(OutputFiles{Mode: OutputFilePerRun}, "job", logger))
+	assert.Nil(t, newJobOutputFiles(OutputFiles{Dir: t.TempDir(), Mode: OutputFileOff}, "job", logger))
+
+	var files *jobOutputFiles
+	file := files.open()
+	assert.Nil(t, file)
+
+	n, err := file.Write([]byte("hello"))
+	assert.Equal(t, 5, n)
+	assert.Nil(t, err)
+	assert.Equal(t, "", file.Close())
+}
+
+func TestOutputFilesPerRun(t *testing.T) {
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+
+	files := newJobOutputFiles(OutputFiles{Dir: dir, Mode: OutputFilePerRun, Retain: 2, Compress: true}, "my job/1", logger)
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	files.now = func() time.Time { return now }
+
+	var paths []string
+	for _, out := range []string{"first\n", "second\n", "third\n"} {
+		file := files.open()
+		file.Write([]byte(out))
+		paths = append(paths, file.Close())
+		now = now.Add(time.Minute)
+	}
+
+	jobDir := filepath.Join(dir, "my_job_1")
+	assert.Equal(t, filepath.Join(jobDir, "2024-05-01T10-02-00.000Z.log.gz"), paths[2])
+	assert.Equal(t, []string{"2024-05-01T10-01-00.000Z.log.gz", "2024-05-01T10-02-00.000Z.log.gz"}, listOutputFiles(t, jobDir))
+	assert.Equal(t, "third\n", readOutputFile(t, paths[2]))
+}
+
+func TestOutputFilesPerRunSameTime(t *testing.T) {
+	logger, _ := newTestLogger()
+
+	files := newJobOutputFiles(OutputFiles{Dir: t.TempDir(), Mode: OutputFilePerRun}, "job", logger)
+	files.now = func() time.Time { return time.Unix(0, 0) }
+
+	first := files.open()
+	second := files.open()
+
+	assert.NotEqual(t, first.Close(), second.Close())
+}
+
+func TestOutputFilesPruneOrdersSuffixesNumerically(t *testing.T) {
+	logger, _ := newTestLogger()
+
+	dir := t.TempDir()
+	files := newJobOutputFiles(OutputFiles{Dir: dir, Mode: OutputFilePerRun, Retain: 3}, "job", logger)
+	files.now = func() time.Time { return time.Unix(0, 0) }
+
+	var runs []*outputFile
+	for i := 0; i < 12; i++ {
+		runs = append(runs, files.open())
+	}
+
+	for _, run := range runs {
+		run.Close()
+	}
+
+	assert.Equal(t, []string{
+		"1970-01-01T00-00-00.000Z_10.log",
+		"1970-01-01T00-00-00.000Z_11.log",
+		"1970-01-01T00-00-00.000Z_9.log",
+	}, listOutputFiles(t, filepath.Join(dir, "job")))
+}
+
+func TestOutputFilesPruneKeepsRunningFiles(t *testing.T) {
+	logger, _ := newTestLogger()
+
+	dir := t.TempDir()
+	files := newJobOutputFiles(OutputFiles{Dir: dir, Mode: OutputFilePerRun, Retain: 1}, "job", logger)
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	files.now = func() time.Time { return now }
+
+	// The oldest run is still running while later runs finish
+	running := files.open()
+	running.Write([]byte("still running\n"))
+
+	for i := 0; i < 3; i++ {
+		now = now.Add(time.Minute)
+		files.open().Close()
+	}
+
+	assert.Equal(t, []string{
+		"2024-05-01T10-00-00.000Z.log",
+		"2024-05-01T10-03-00.000Z.log",
+	}, listOutputFiles(t, filepath.Join(dir, "job")))
+
+	// Once finished, it is the oldest run
+	running.Close()
+	assert.Equal(t, []string{"2024-05-01T10-03-00.000Z.log"}, listOutputFiles(t, filepath.Join(dir, "job")))
+}
+
+func TestOutputFilesRolling(t *testing.T) {
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+
+	files := newJobOutputFiles(OutputFiles{Dir: dir, Mode: OutputFileRolling, MaxSize: 10, MaxAge: time.Hour, Retain: 2}, "job", logger)
+
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	files.now = func() time.Time { return now }
+
+	first := files.open()
+	second := files.open()
+
+	// Rotated because of its size
+	first.Write([]byte("12345\n"))
+	first.Write([]byte("6789\n"))
+
+	// Runs append to the same file
+	second.Write([]byte("a\n"))
+
+	// Rotated because of its age
+	now = now.Add(time.Hour)
+	first.Write([]byte("b\n"))
+
+	// Rotated again at the same time, and only the last rotated files
+	// are kept
+	first.Write([]byte("0123456789\n"))
+
+	path := first.Close()
+	assert.Equal(t, filepath.Join(dir, "job", "output.log"), path)
+	assert.Equal(t, path, second.Close())
+
+	jobDir := filepath.Join(dir, "job")
+	assert.Equal(t, []string{
+		"output-2024-05-01T11-00-00.000Z.log",
+		"output-2024-05-01T11-00-00.000Z_1.log",
+		"output.log",
+	}, listOutputFiles(t, jobDir))
+	assert.Equal(t, "6789\na\n", readOutputFile(t, filepath.Join(jobDir, "output-2024-05-01T11-00-00.000Z.log")))
+	assert.Equal(t, "b\n", readOutputFile(t, filepath.Join(jobDir, "output-2024-05-01T11-00-00.000Z_1.log")))
+	assert.Equal(t, "0123456789\n", readOutputFile(t, path))
+
+	// The file is closed once all runs are finished
+	assert.Nil(t, files.rolling)
+}
+
+func TestRunJobWritesOutputFile(t *testing.T) {
+	logger, channel := newTestLogger()
+	dir := t.TempDir()
+
+	job := &crontab.Job{Name: "job"}
+	opts := &Options{OutputFiles: OutputFiles{Dir: dir, Mode: OutputFilePerRun, Only: true}}
+
+	output := newOutputConfig(opts, job, logger).newRun()
+
+	err := runJob(&basicContext, "echo hello; echo oops >&2", logger, output)
+	assert.Nil(t, err)
+
+	path := output.file.Close()
+	assert.Equal(t, filepath.Join(dir, "job"), filepath.Dir(path))
+	assert.ElementsMatch(t, []string{"hello", "oops"}, strings.Fields(readOutputFile(t, path)))
+
+	// Lines are not logged, but are kept for failure reports
+	entry := <-channel
+	assert.Equal(t, "starting", entry.Message)
+	assert.Empty(t, channel)
+}
@@ -0,0 +1,98 @@
+package cron
+
+import (
+	"sync"
+	"time"
+)
+
+// outputLimiter limits how much of the output of a run is logged: a number
+// of lines per second (with a burst), and a total number of bytes. It is
+// shared by the stdout and stderr of the run. A nil *outputLimiter allows
+// everything.
+type outputLimiter struct {
+	rate      float64
+	burst     float64
+	byteLimit int64
+
+	mu      sync.Mutex
+	tokens  float64
+	last    time.Time
+	bytes   int64
+	dropped map[string]uint64
+	now     func() time.Time
+}
+
+func newOutputLimiter(rate float64, burst int, byteLimit int64) *outputLimiter {
+	if rate <= 0 && byteLimit <= 0 {
+		return nil
+	}
+
+	b := float64(burst)
+	if b < 1 {
+		b = rate
+		if b < 1 {
+			b = 1
+		}
+	}
+
+	return &outputLimiter{
+		rate:      rate,
+		burst:     b,
+		byteLimit: byteLimit,
+		tokens:    b,
+		dropped:   make(map[string]uint64),
+		now:       time.Now,
+	}
+}
+
+// allow reports whether a line of n bytes written to channel can be logged,
+// and counts it as dropped otherwise.
+func (l *outputLimiter) allow(channel string, n int) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 {
+		now := l.now()
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.rate
+			if l.tokens > l.burst {
+				l.tokens = l.burst
+			}
+		}
+		l.last = now
+	}
+
+	if (l.rate > 0 && l.tokens < 1) || (l.byteLimit > 0 && l.bytes+int64(n) > l.byteLimit) {
+		l.dropped[channel]++
+		return false
+	}
+
+	if l.rate > 0 {
+		l.tokens--
+	}
+	l.bytes += int64(n)
+
+	return true
+}
+
+// droppedLines returns the number of lines dropped on each channel.
+func (l *outputLimiter) droppedLines() map[string]uint64 {
+	dropped := make(map[string]uint64)
+
+	if l == nil {
+		return dropped
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for channel, n := range l.dropped {
+		dropped[channel] = n
+	}
+
+	return dropped
+}
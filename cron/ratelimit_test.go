@@ -0,0 +1,73 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+func TestOutputLimiterDisabled(t *testing.T) {
+	limiter := newOutputLimiter(0, 10, 0)
+	assert.Nil(t, limiter)
+
+	assert.True(t, limiter.allow("stdout", 100))
+	assert.Empty(t, limiter.droppedLines())
+}
+
+func TestOutputLimiterRate(t *testing.T) {
+	limiter := newOutputLimiter(2, 3, 0)
+
+	now := time.Unix(0, 0)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("stdout", 1))
+	}
+	assert.False(t, limiter.allow("stdout", 1))
+	assert.False(t, limiter.allow("stderr", 1))
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, limiter.allow("stdout", 1))
+	assert.False(t, limiter.allow("stdout", 1))
+
+	// Tokens never exceed the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.allow("stderr", 1))
+	}
+	assert.False(t, limiter.allow("stderr", 1))
+
+	assert.Equal(t, map[string]uint64{"stdout": 2, "stderr": 2}, limiter.droppedLines())
+}
+
+func TestOutputLimiterBytes(t *testing.T) {
+	limiter := newOutputLimiter(0, 0, 10)
+
+	assert.True(t, limiter.allow("stdout", 6))
+	assert.False(t, limiter.allow("stdout", 6))
+	assert.True(t, limiter.allow("stderr", 4))
+	assert.False(t, limiter.allow("stderr", 1))
+
+	assert.Equal(t, map[string]uint64{"stdout": 1, "stderr": 1}, limiter.droppedLines())
+}
+
+func TestJobOutputDropsLinesOverLimit(t *testing.T) {
+	logger, channel := newTestLogger()
+
+	job := &crontab.Job{Annotations: map[string]string{"output.byte-limit": "5"}}
+	output := newOutputConfig(&Options{OutputTailLines: 10}, job, logger).newRun()
+
+	output.logLine(logger, "stdout", "hello")
+	output.logLine(logger, "stdout", "world")
+
+	entry := <-channel
+	assert.Equal(t, "hello", entry.Message)
+	assert.Empty(t, channel)
+
+	// Dropped lines are still kept for failure reports
+	assert.Equal(t, []string{"hello", "world"}, output.tail.lines())
+	assert.Equal(t, map[string]uint64{"stdout": 1}, output.droppedLines())
+}
@@ -0,0 +1,133 @@
+package cron
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/aptible/supercronic/notify"
+)
+
+// outputTail is a ring buffer that keeps the last lines a job wrote to its
+// stdout and stderr, bounded both in number of lines and in bytes, so that its
+// memory usage does not depend on how much the job prints.
+type outputTail struct {
+	mu       sync.Mutex
+	buf      []string
+	start    int
+	count    int
+	size     int
+	maxBytes int
+}
+
+// newOutputTail returns a tail that keeps up to maxLines lines and maxBytes
+// bytes. A maxBytes of 0 only limits the number of lines.
+func newOutputTail(maxLines int, maxBytes int) *outputTail {
+	if maxLines < 0 {
+		maxLines = 0
+	}
+
+	return &outputTail{
+		buf:      make([]string, maxLines),
+		maxBytes: maxBytes,
+	}
+}
+
+func (t *outputTail) add(line string) {
+	if t == nil || len(t.buf) == 0 {
+		return
+	}
+
+	if t.maxBytes > 0 {
+		line = notify.LastBytes(line, t.maxBytes)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.count == len(t.buf) {
+		t.evict()
+	}
+
+	for t.maxBytes > 0 && t.count > 0 && t.size+len(line) > t.maxBytes {
+		t.evict()
+	}
+
+	t.buf[(t.start+t.count)%len(t.buf)] = line
+	t.count++
+	t.size += len(line)
+}
+
+func (t *outputTail) evict() {
+	t.size -= len(t.buf[t.start])
+	t.buf[t.start] = ""
+	t.start = (t.start + 1) % len(t.buf)
+	t.count--
+}
+
+func (t *outputTail) lines() []string {
+	lines := []string{}
+
+	if t == nil {
+		return lines
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := 0; i < t.count; i++ {
+		lines = append(lines, t.buf[(t.start+i)%len(t.buf)])
+	}
+
+	return lines
+}
+
+func (t *outputTail) String() string {
+	return strings.Join(t.lines(), "\n")
+}
+
+// outputCapture keeps the end of the output of a run for email, bounded in
+// bytes but not in lines. When the output is larger than maxBytes, it keeps
+// a little more than that, so that the email notifier knows to truncate it.
+// A maxBytes of 0 disables the capture.
+type outputCapture struct {
+	mu       sync.Mutex
+	buf      []string
+	size     int
+	maxBytes int
+}
+
+func newOutputCapture(maxBytes int) *outputCapture {
+	return &outputCapture{maxBytes: maxBytes}
+}
+
+func (c *outputCapture) add(line string) {
+	if c == nil || c.maxBytes <= 0 {
+		return
+	}
+
+	line = notify.LastBytes(line, c.maxBytes+1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf = append(c.buf, line)
+	c.size += len(line) + 1
+
+	// Drop the oldest lines, as long as what is left is still too large
+	for len(c.buf) > 1 && c.size-len(c.buf[0])-1 > c.maxBytes {
+		c.size -= len(c.buf[0]) + 1
+		c.buf[0] = ""
+		c.buf = c.buf[1:]
+	}
+}
+
+func (c *outputCapture) lines() []string {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.buf...)
+}
@@ -0,0 +1,82 @@
+package cron
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputTailKeepsLastLines(t *testing.T) {
+	tail := newOutputTail(3, 0)
+
+	for i := 0; i < 10; i++ {
+		tail.add(fmt.Sprintf("line %d", i))
+	}
+
+	assert.Equal(t, []string{"line 7", "line 8", "line 9"}, tail.lines())
+	assert.Equal(t, "line 7\nline 8\nline 9", tail.String())
+}
+
+func TestOutputTailIsBoundedInBytes(t *testing.T) {
+	tail := newOutputTail(100, 10)
+
+	tail.add("aaaa")
+	tail.add("bbbb")
+	tail.add("cccc")
+
+	assert.Equal(t, []string{"bbbb", "cccc"}, tail.lines())
+
+	tail.add(strings.Repeat("x", 100) + "0123456789")
+	assert.Equal(t, []string{"0123456789"}, tail.lines())
+	assert.Equal(t, 10, tail.size)
+}
+
+func TestOutputTailMemoryStaysFixed(t *testing.T) {
+	tail := newOutputTail(5, 1024)
+
+	for i := 0; i < 100000; i++ {
+		tail.add(strings.Repeat("y", i%2048))
+	}
+
+	assert.Len(t, tail.buf, 5)
+	assert.True(t, tail.size <= 1024)
+}
+
+func TestOutputTailDisabled(t *testing.T) {
+	tail := newOutputTail(0, 0)
+	tail.add("foo")
+	assert.Equal(t, []string{}, tail.lines())
+
+	var nilTail *outputTail
+	nilTail.add("foo")
+	assert.Equal(t, "", nilTail.String())
+}
+
+func TestOutputCaptureIsBoundedInBytes(t *testing.T) {
+	capture := newOutputCapture(10)
+
+	capture.add("aaaa")
+	capture.add("bbbb")
+	assert.Equal(t, []string{"aaaa", "bbbb"}, capture.lines())
+
+	// Just enough lines are kept to show the output was longer than 10
+	// bytes
+	capture.add("cccc")
+	assert.Equal(t, []string{"aaaa", "bbbb", "cccc"}, capture.lines())
+
+	capture.add("dddd")
+	assert.Equal(t, []string{"bbbb", "cccc", "dddd"}, capture.lines())
+
+	for i := 0; i < 100000; i++ {
+		capture.add(strings.Repeat("y", i%2048))
+	}
+	assert.True(t, capture.size <= 2*(10+2))
+}
+
+func TestOutputCaptureDisabled(t *testing.T) {
+	capture := newOutputCapture(0)
+	capture.add("foo")
+	assert.Nil(t, capture.lines())
+}
@@ -15,6 +15,21 @@ import (
 var (
 	jobLineSeparator = regexp.MustCompile(`\S+`)
 	envLineMatcher   = regexp.MustCompile(`^([^\s=]+)\s*=\s*(.*)$`)
+	annotationLine   = regexp.MustCompile(`^#\s*@([A-Za-z][\w.-]*)(?:\s+(.*?))?\s*$`)
+
+	// cronShorthands are not annotations: a comment starting with one is a
+	// commented out job (e.g. "#@daily /bin/cleanup").
+	cronShorthands = map[string]bool{
+		"yearly":   true,
+		"annually": true,
+		"monthly":  true,
+		"weekly":   true,
+		"daily":    true,
+		"midnight": true,
+		"hourly":   true,
+		"reboot":   true,
+		"every":    true,
+	}
 
 	parameterCounts = []int{
 		7, // POSIX + seconds + years
@@ -68,6 +83,10 @@ func ParseCrontab(reader io.Reader) (*Crontab, error) {
 	shell := "/bin/sh"
 	tz := time.Local
 
+	// Annotations are comment lines of the form "# @key value" and apply
+	// to the job line that follows them.
+	annotations := make(map[string]string)
+
 	for scanner.Scan() {
 		line := strings.TrimLeft(scanner.Text(), " \t")
 
@@ -76,6 +95,9 @@ func ParseCrontab(reader io.Reader) (*Crontab, error) {
 		}
 
 		if line[0] == '#' {
+			if r := annotationLine.FindStringSubmatch(line); r != nil && !cronShorthands[strings.ToLower(r[1])] {
+				annotations[r[1]] = r[2]
+			}
 			continue
 		}
 
@@ -119,8 +141,20 @@ func ParseCrontab(reader io.Reader) (*Crontab, error) {
 			return nil, err
 		}
 
-		jobs = append(jobs, &Job{CrontabLine: *jobLine, Position: position})
+		name := annotations["name"]
+		if name == "" {
+			name = fmt.Sprintf("job-%d", position)
+		}
+
+		jobs = append(jobs, &Job{
+			CrontabLine: *jobLine,
+			Position:    position,
+			Name:        name,
+			Annotations: annotations,
+		})
 		position++
+
+		annotations = make(map[string]string)
 	}
 
 	if err := scanner.Err(); err != nil {
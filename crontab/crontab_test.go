@@ -310,3 +310,50 @@ func TestParseCrontab(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCrontabAnnotations(t *testing.T) {
+	reader := bytes.NewBufferString(`# A regular comment
+# @name backup
+#@hook.before   echo before
+* * * * * echo job1
+
+# @hook.after echo after
+* * * * * echo job2
+`)
+
+	crontab, err := ParseCrontab(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if assert.Len(t, crontab.Jobs, 2) {
+		assert.Equal(t, "backup", crontab.Jobs[0].Name)
+		assert.Equal(t, map[string]string{
+			"name":        "backup",
+			"hook.before": "echo before",
+		}, crontab.Jobs[0].Annotations)
+
+		assert.Equal(t, "job-1", crontab.Jobs[1].Name)
+		assert.Equal(t, map[string]string{
+			"hook.after": "echo after",
+		}, crontab.Jobs[1].Annotations)
+	}
+}
+
+func TestParseCrontabCommentedOutShorthandJobs(t *testing.T) {
+	reader := bytes.NewBufferString(`#@daily /bin/cleanup
+# @hourly /bin/rotate
+# @Reboot /bin/start
+* * * * * echo job
+`)
+
+	crontab, err := ParseCrontab(reader)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	if assert.Len(t, crontab.Jobs, 1) {
+		assert.Equal(t, "job-0", crontab.Jobs[0].Name)
+		assert.Empty(t, crontab.Jobs[0].Annotations)
+	}
+}
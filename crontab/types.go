@@ -16,7 +16,9 @@ type CrontabLine struct {
 
 type Job struct {
 	CrontabLine
-	Position int
+	Position    int
+	Name        string
+	Annotations map[string]string
 }
 
 type Context struct {
@@ -0,0 +1,52 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ECSVersion is the version of the Elastic Common Schema ECSFormatter
+// follows.
+const ECSVersion = "8.11.0"
+
+// ecsFields maps logrus fields to ECS fields.
+var ecsFields = map[string]string{
+	logrus.ErrorKey: "error.message",
+	"iteration":     "event.sequence",
+	"channel":       "log.origin.channel",
+}
+
+// ECSFormatter formats entries as JSON following the Elastic Common Schema:
+// "@timestamp", "log.level", "message", "ecs.version" and "event.*". Other
+// fields are kept as is.
+type ECSFormatter struct {
+	TimestampFormat string
+}
+
+func (f *ECSFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(map[string]interface{}, len(entry.Data)+6)
+
+	for k, v := range entry.Data {
+		if name, ok := ecsFields[k]; ok {
+			k = name
+		}
+		data[k] = fieldValue(v)
+	}
+
+	data["@timestamp"] = entry.Time.Format(timestampFormat(f.TimestampFormat, time.RFC3339Nano))
+	data["log.level"] = entry.Level.String()
+	data["message"] = entry.Message
+	data["ecs.version"] = ECSVersion
+	data["event.dataset"] = "supercronic"
+	data["event.kind"] = "event"
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+// Package formatter provides the logrus formatters selected with -log-format.
+package formatter
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options configures the formatters returned by New. TimestampFormat and
+// FieldMap apply to the text, json and logfmt formats, Template to the
+// template format, and Host to the gelf format.
+type Options struct {
+	TimestampFormat string
+	FieldMap        logrus.FieldMap
+	Template        string
+	Host            string
+}
+
+// New returns the formatter for format: text, json, ecs, gelf, logfmt or
+// template.
+func New(format string, opts Options) (logrus.Formatter, error) {
+	switch format {
+	case "text":
+		f := &logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: opts.TimestampFormat,
+			FieldMap:        opts.FieldMap,
+		}
+		return f, nil
+	case "json":
+		return &logrus.JSONFormatter{
+			TimestampFormat: opts.TimestampFormat,
+			FieldMap:        opts.FieldMap,
+		}, nil
+	case "ecs":
+		return &ECSFormatter{TimestampFormat: opts.TimestampFormat}, nil
+	case "gelf":
+		host := opts.Host
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		return &GELFFormatter{Host: host}, nil
+	case "logfmt":
+		return &LogfmtFormatter{
+			TimestampFormat: opts.TimestampFormat,
+			FieldMap:        opts.FieldMap,
+		}, nil
+	case "template":
+		return NewTemplateFormatter(opts.Template)
+	default:
+		return nil, fmt.Errorf("unknown log format: %q", format)
+	}
+}
+
+var timestampFormats = map[string]string{
+	"s":  "2006-01-02T15:04:05Z07:00",
+	"ms": "2006-01-02T15:04:05.000Z07:00",
+	"us": "2006-01-02T15:04:05.000000Z07:00",
+	"ns": "2006-01-02T15:04:05.000000000Z07:00",
+}
+
+// ParseTimestampPrecision returns the RFC 3339 timestamp format with the
+// given precision: s, ms, us or ns.
+func ParseTimestampPrecision(s string) (string, error) {
+	format, ok := timestampFormats[s]
+	if !ok {
+		return "", fmt.Errorf("unknown timestamp precision: %q", s)
+	}
+
+	return format, nil
+}
+
+// ParseFieldMap parses KEY=NAME pairs renaming the time, level and msg keys
+// of log entries.
+func ParseFieldMap(pairs []string) (logrus.FieldMap, error) {
+	fieldMap := logrus.FieldMap{}
+
+	for _, pair := range pairs {
+		key, name, ok := strings.Cut(pair, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid key name: %q (expected KEY=NAME)", pair)
+		}
+
+		switch key {
+		case logrus.FieldKeyTime:
+			fieldMap[logrus.FieldKeyTime] = name
+		case logrus.FieldKeyLevel:
+			fieldMap[logrus.FieldKeyLevel] = name
+		case logrus.FieldKeyMsg:
+			fieldMap[logrus.FieldKeyMsg] = name
+		default:
+			return nil, fmt.Errorf("invalid key name: %q (expected time, level or msg)", key)
+		}
+	}
+
+	return fieldMap, nil
+}
+
+// fieldValue returns the value logged for a field.
+func fieldValue(v interface{}) interface{} {
+	if err, ok := v.(error); ok {
+		return err.Error()
+	}
+
+	return v
+}
+
+func timestampFormat(format string, fallback string) string {
+	if format == "" {
+		return fallback
+	}
+
+	return format
+}
@@ -0,0 +1,147 @@
+package formatter
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntry(fields logrus.Fields, msg string) *logrus.Entry {
+	return &logrus.Entry{
+		Logger:  logrus.New(),
+		Data:    fields,
+		Time:    time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC),
+		Level:   logrus.WarnLevel,
+		Message: msg,
+	}
+}
+
+func format(t *testing.T, f logrus.Formatter, entry *logrus.Entry) string {
+	b, err := f.Format(entry)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	return string(b)
+}
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{"text", "json", "ecs", "gelf", "logfmt"} {
+		_, err := New(name, Options{})
+		assert.Nil(t, err, name)
+	}
+
+	_, err := New("template", Options{})
+	assert.NotNil(t, err)
+
+	_, err = New("yaml", Options{})
+	assert.NotNil(t, err)
+}
+
+func TestJSONKeysAndPrecision(t *testing.T) {
+	tsFormat, err := ParseTimestampPrecision("ms")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	fieldMap, err := ParseFieldMap([]string{"time=ts", "msg=message"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	f, err := New("json", Options{TimestampFormat: tsFormat, FieldMap: fieldMap})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(format(t, f, testEntry(logrus.Fields{}, "hello"))), &data))
+	assert.Equal(t, map[string]interface{}{
+		"ts":      "2024-05-01T10:00:00.123Z",
+		"level":   "warning",
+		"message": "hello",
+	}, data)
+}
+
+func TestParseFieldMapInvalid(t *testing.T) {
+	for _, pair := range []string{"time", "time=", "func=f"} {
+		_, err := ParseFieldMap([]string{pair})
+		assert.NotNil(t, err, pair)
+	}
+
+	_, err := ParseTimestampPrecision("m")
+	assert.NotNil(t, err)
+}
+
+func TestECSFormatter(t *testing.T) {
+	f := &ECSFormatter{}
+
+	out := format(t, f, testEntry(logrus.Fields{
+		"job.name":  "backup",
+		"iteration": 3,
+		"channel":   "stdout",
+		"error":     errors.New("boom"),
+	}, "hello"))
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &data))
+	assert.Equal(t, map[string]interface{}{
+		"@timestamp":         "2024-05-01T10:00:00.123456789Z",
+		"log.level":          "warning",
+		"message":            "hello",
+		"ecs.version":        ECSVersion,
+		"event.dataset":      "supercronic",
+		"event.kind":         "event",
+		"event.sequence":     float64(3),
+		"log.origin.channel": "stdout",
+		"error.message":      "boom",
+		"job.name":           "backup",
+	}, data)
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+	f := &LogfmtFormatter{FieldMap: logrus.FieldMap{logrus.FieldKeyMsg: "message"}}
+
+	out := format(t, f, testEntry(logrus.Fields{
+		"job.command": `echo "hi"`,
+		"iteration":   3,
+		"empty":       "",
+		"bad key":     "x=y",
+		"error":       errors.New("boom"),
+	}, "line 1\nline 2"))
+
+	assert.Equal(
+		t,
+		`time=2024-05-01T10:00:00Z level=warning message="line 1\nline 2" bad_key="x=y" empty="" error=boom iteration=3 job.command="echo \"hi\""`+"\n",
+		out,
+	)
+}
+
+func TestTemplateFormatter(t *testing.T) {
+	f, err := NewTemplateFormatter(`{{ rfc3339 .Time }} {{ upper .Level }} [{{ index .Data "job.name" }}] {{ .Message }} {{ json .Data.error }}`)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	out := format(t, f, testEntry(logrus.Fields{"job.name": "backup", "error": errors.New("boom")}, "hello"))
+	assert.Equal(t, `2024-05-01T10:00:00.123456789Z WARNING [backup] hello "boom"`+"\n", out)
+}
+
+func TestTemplateFormatterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.tmpl")
+	assert.Nil(t, os.WriteFile(path, []byte("{{ .Message }}\n"), 0644))
+
+	f, err := NewTemplateFormatter("@" + path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, "hello\n", format(t, f, testEntry(logrus.Fields{}, "hello")))
+
+	_, err = NewTemplateFormatter("{{ .Message")
+	assert.NotNil(t, err)
+}
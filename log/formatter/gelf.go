@@ -0,0 +1,317 @@
+package formatter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gelfLevels maps logrus levels to the syslog severities used by GELF.
+var gelfLevels = map[logrus.Level]int{
+	logrus.PanicLevel: 1,
+	logrus.FatalLevel: 2,
+	logrus.ErrorLevel: 3,
+	logrus.WarnLevel:  4,
+	logrus.InfoLevel:  6,
+	logrus.DebugLevel: 7,
+	logrus.TraceLevel: 7,
+}
+
+var invalidGELFFieldChars = regexp.MustCompile(`[^\w.-]`)
+
+// GELFFormatter formats entries as GELF 1.1 messages. Fields are sent as
+// additional fields (e.g. "job.name" as "_job.name").
+type GELFFormatter struct {
+	Host string
+}
+
+func (f *GELFFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	level, ok := gelfLevels[entry.Level]
+	if !ok {
+		level = 6
+	}
+
+	short, _, multiline := strings.Cut(entry.Message, "\n")
+	if short == "" {
+		short = "-"
+	}
+
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          f.Host,
+		"short_message": short,
+		"timestamp":     float64(entry.Time.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         level,
+	}
+
+	if multiline {
+		msg["full_message"] = entry.Message
+	}
+
+	for k, v := range entry.Data {
+		name := "_" + invalidGELFFieldChars.ReplaceAllString(k, "_")
+		if name == "_id" {
+			name = "_id_"
+		}
+
+		switch v := fieldValue(v).(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, json.Number:
+			msg[name] = v
+		default:
+			msg[name] = fmt.Sprint(v)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(msg); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+const (
+	gelfChunkSize = 8192
+	gelfMaxChunks = 128
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GELFWriter sends each write, a GELF message, to a GELF server over UDP
+// (chunked if needed) or TCP (null byte delimited). It is meant to be the
+// output of a logger using GELFFormatter.
+//
+// Messages are sent in the background, so that a slow or missing server never
+// blocks jobs: they are dropped when the queue is full or when they cannot be
+// sent, and drops are reported to stderr at most once a minute.
+type GELFWriter struct {
+	network string
+	address string
+	timeout time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	messages chan []byte
+	stopped  chan struct{}
+	dropped  uint64
+
+	// Only used by run
+	conn           net.Conn
+	lastErr        error
+	reported       uint64
+	lastReport     time.Time
+	reportInterval time.Duration
+	reportOutput   io.Writer
+}
+
+// NewGELFWriter returns a GELFWriter for udp://HOST:PORT or tcp://HOST:PORT.
+// At most 1024 messages wait to be sent.
+func NewGELFWriter(address string) (*GELFWriter, error) {
+	return newGELFWriter(address, 1024)
+}
+
+func newGELFWriter(address string, queueSize int) (*GELFWriter, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GELF address: %w", err)
+	}
+
+	if (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+		return nil, fmt.Errorf("invalid GELF address: %q (expected udp://HOST:PORT or tcp://HOST:PORT)", address)
+	}
+
+	w := &GELFWriter{
+		network:        u.Scheme,
+		address:        u.Host,
+		timeout:        5 * time.Second,
+		messages:       make(chan []byte, queueSize),
+		stopped:        make(chan struct{}),
+		reportInterval: time.Minute,
+		reportOutput:   os.Stderr,
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+// Write queues p to be sent, or drops it if the queue is full. It never
+// blocks on the network.
+func (w *GELFWriter) Write(p []byte) (int, error) {
+	msg := bytes.TrimRight(p, "\n")
+
+	if w.network == "udp" && len(msg) > (gelfChunkSize-12)*gelfMaxChunks {
+		return 0, errors.New("failed to send log to GELF server: message too large")
+	}
+
+	// The logger reuses p once Write returns
+	msg = append([]byte(nil), msg...)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		atomic.AddUint64(&w.dropped, 1)
+		return len(p), nil
+	}
+
+	select {
+	case w.messages <- msg:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns the number of messages that were dropped because the
+// queue was full or they could not be sent.
+func (w *GELFWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// run sends queued messages until the writer is closed.
+func (w *GELFWriter) run() {
+	defer close(w.stopped)
+
+	for msg := range w.messages {
+		// Retry once, in case the server closed the connection since the
+		// last message
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			if err = w.send(msg); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			atomic.AddUint64(&w.dropped, 1)
+			w.lastErr = err
+		}
+
+		w.report()
+	}
+
+	w.report()
+
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// report writes the number of messages dropped since the last report to
+// stderr, at most once per reportInterval.
+func (w *GELFWriter) report() {
+	dropped := atomic.LoadUint64(&w.dropped)
+	if dropped == w.reported || time.Since(w.lastReport) < w.reportInterval {
+		return
+	}
+
+	reason := "queue is full"
+	if w.lastErr != nil {
+		reason = w.lastErr.Error()
+	}
+
+	fmt.Fprintf(w.reportOutput, "supercronic: dropped %d logs that could not be sent to the GELF server: %s\n", dropped-w.reported, reason)
+
+	w.reported = dropped
+	w.lastReport = time.Now()
+	w.lastErr = nil
+}
+
+// send sends msg, connecting to the server first if needed. It must only be
+// called by run.
+func (w *GELFWriter) send(msg []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, w.timeout)
+		if err != nil {
+			return err
+		}
+		w.conn = conn
+	}
+
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+
+	var err error
+	if w.network == "tcp" {
+		_, err = w.conn.Write(append(msg, 0))
+	} else {
+		err = w.sendChunks(msg)
+	}
+
+	if err != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	return err
+}
+
+func (w *GELFWriter) sendChunks(msg []byte) error {
+	if len(msg) <= gelfChunkSize {
+		_, err := w.conn.Write(msg)
+		return err
+	}
+
+	size := gelfChunkSize - 12
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return errors.New("message too large")
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		chunk := make([]byte, 0, 12+end-i*size)
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, msg[i*size:end]...)
+
+		if _, err := w.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close stops accepting messages, and waits for the queued ones to be sent,
+// for at most the timeout of the writer.
+func (w *GELFWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.messages)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.stopped:
+	case <-time.After(w.timeout):
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package formatter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGELFFormatter(t *testing.T) {
+	f := &GELFFormatter{Host: "myhost"}
+
+	out := format(t, f, testEntry(logrus.Fields{
+		"job.name":  "backup",
+		"iteration": 3,
+		"id":        "x",
+		"bad key":   []string{"a"},
+	}, "line 1\nline 2"))
+
+	var data map[string]interface{}
+	assert.Nil(t, json.Unmarshal([]byte(out), &data))
+	assert.Equal(t, map[string]interface{}{
+		"version":       "1.1",
+		"host":          "myhost",
+		"short_message": "line 1",
+		"full_message":  "line 1\nline 2",
+		"timestamp":     1714557600.123,
+		"level":         float64(4),
+		"_job.name":     "backup",
+		"_iteration":    float64(3),
+		"_id_":          "x",
+		"_bad_key":      "[a]",
+	}, data)
+}
+
+func TestGELFWriterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	w, err := NewGELFWriter("udp://" + conn.LocalAddr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer w.Close()
+
+	read := func() []byte {
+		buf := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if !assert.Nil(t, err) {
+			t.FailNow()
+		}
+		return buf[:n]
+	}
+
+	_, err = w.Write([]byte("{\"short_message\":\"hello\"}\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"short_message":"hello"}`, string(read()))
+
+	// Large messages are chunked
+	large := `{"short_message":"` + strings.Repeat("x", 20000) + `"}`
+	_, err = w.Write([]byte(large))
+	assert.Nil(t, err)
+
+	var reassembled []byte
+	var id []byte
+	for i := 0; i < 3; i++ {
+		chunk := read()
+		assert.Equal(t, gelfChunkMagic, chunk[:2])
+		if id == nil {
+			id = chunk[2:10]
+		}
+		assert.Equal(t, id, chunk[2:10])
+		assert.Equal(t, byte(i), chunk[10])
+		assert.Equal(t, byte(3), chunk[11])
+		reassembled = append(reassembled, chunk[12:]...)
+	}
+	assert.Equal(t, large, string(reassembled))
+
+	_, err = w.Write(bytes.Repeat([]byte("x"), gelfChunkSize*gelfMaxChunks))
+	assert.NotNil(t, err)
+}
+
+func TestGELFWriterTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	messages := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			messages <- strings.TrimSuffix(msg, "\x00")
+		}
+	}()
+
+	w, err := NewGELFWriter("tcp://" + listener.Addr().String())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer w.Close()
+
+	logger := logrus.New()
+	logger.SetFormatter(&GELFFormatter{Host: "myhost"})
+	logger.SetOutput(w)
+	logger.Info("hello")
+	logger.Info("world")
+
+	for _, expected := range []string{"hello", "world"} {
+		select {
+		case msg := <-messages:
+			var data map[string]interface{}
+			assert.Nil(t, json.Unmarshal([]byte(msg), &data))
+			assert.Equal(t, expected, data["short_message"])
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timed out waiting for GELF message")
+		}
+	}
+}
+
+func TestGELFWriterDoesNotBlockWhenServerIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	w, err := NewGELFWriter("tcp://" + address)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var report bytes.Buffer
+	w.reportInterval = time.Hour
+	w.reportOutput = &report
+
+	for i := 0; i < 3; i++ {
+		_, err = w.Write([]byte("{\"short_message\":\"hello\"}\n"))
+		assert.Nil(t, err)
+	}
+
+	w.Close()
+
+	assert.Equal(t, uint64(3), w.Dropped())
+
+	// Failures are reported once per interval, not for every message
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], "dropped 1 logs that could not be sent to the GELF server")
+	}
+}
+
+func TestGELFWriterDropsWhenQueueIsFull(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	// Accept connections, but never read from them
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	w, err := newGELFWriter("tcp://"+listener.Addr().String(), 1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	w.timeout = 100 * time.Millisecond
+	w.reportOutput = io.Discard
+
+	large := bytes.Repeat([]byte("x"), 1<<20)
+
+	start := time.Now()
+	for i := 0; i < 32; i++ {
+		_, err = w.Write(large)
+		assert.Nil(t, err)
+	}
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	assert.True(t, w.Dropped() > 0)
+
+	w.Close()
+}
+
+func TestNewGELFWriterInvalid(t *testing.T) {
+	for _, address := range []string{"http://localhost:12201", "udp://", "localhost:12201"} {
+		_, err := NewGELFWriter(address)
+		assert.NotNil(t, err, address)
+	}
+}
@@ -0,0 +1,90 @@
+package formatter
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogfmtFormatter formats entries as strict logfmt: one line of key=value
+// pairs, with time, level and msg first and the other fields sorted. Unlike
+// logrus.TextFormatter, it never colors or pads its output, and always quotes
+// values that need it.
+type LogfmtFormatter struct {
+	TimestampFormat string
+	FieldMap        logrus.FieldMap
+}
+
+func (f *LogfmtFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var b bytes.Buffer
+
+	timeKey, levelKey, msgKey := logrus.FieldKeyTime, logrus.FieldKeyLevel, logrus.FieldKeyMsg
+	if name, ok := f.FieldMap[logrus.FieldKeyTime]; ok {
+		timeKey = name
+	}
+	if name, ok := f.FieldMap[logrus.FieldKeyLevel]; ok {
+		levelKey = name
+	}
+	if name, ok := f.FieldMap[logrus.FieldKeyMsg]; ok {
+		msgKey = name
+	}
+
+	writeLogfmtPair(&b, timeKey, entry.Time.Format(timestampFormat(f.TimestampFormat, time.RFC3339)))
+	writeLogfmtPair(&b, levelKey, entry.Level.String())
+	writeLogfmtPair(&b, msgKey, entry.Message)
+
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		writeLogfmtPair(&b, k, fmt.Sprint(fieldValue(entry.Data[k])))
+	}
+
+	b.WriteByte('\n')
+
+	return b.Bytes(), nil
+}
+
+func writeLogfmtPair(b *bytes.Buffer, key string, value string) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+
+	b.WriteString(logfmtKey(key))
+	b.WriteByte('=')
+
+	if value != "" && !strings.ContainsFunc(value, needsLogfmtQuotes) {
+		b.WriteString(value)
+		return
+	}
+
+	b.WriteString(fmt.Sprintf("%q", value))
+}
+
+func needsLogfmtQuotes(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+}
+
+// logfmtKey replaces the characters that are not allowed in keys.
+func logfmtKey(key string) string {
+	key = strings.Map(func(r rune) rune {
+		if needsLogfmtQuotes(r) {
+			return '_'
+		}
+		return r
+	}, key)
+
+	if key == "" {
+		return "_"
+	}
+
+	return key
+}
@@ -0,0 +1,87 @@
+package formatter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"rfc3339": func(t time.Time) string {
+		return t.Format(time.RFC3339Nano)
+	},
+	"upper": strings.ToUpper,
+}
+
+// templateEntry is what log templates are executed with.
+type templateEntry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	Data    map[string]interface{}
+}
+
+// TemplateFormatter formats entries with a Go template, executed with the
+// Time, Level, Message and Data (the fields) of the entry. A newline is
+// added to the output if the template does not end with one.
+type TemplateFormatter struct {
+	Template *template.Template
+}
+
+// NewTemplateFormatter returns a TemplateFormatter for text, or for the
+// contents of a file if text starts with "@".
+func NewTemplateFormatter(text string) (*TemplateFormatter, error) {
+	if strings.HasPrefix(text, "@") {
+		b, err := os.ReadFile(text[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read log template: %w", err)
+		}
+		text = string(b)
+	}
+
+	if text == "" {
+		return nil, fmt.Errorf("log template is empty")
+	}
+
+	tmpl, err := template.New("log").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log template: %w", err)
+	}
+
+	return &TemplateFormatter{Template: tmpl}, nil
+}
+
+func (f *TemplateFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(map[string]interface{}, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = fieldValue(v)
+	}
+
+	var b bytes.Buffer
+
+	err := f.Template.Execute(&b, templateEntry{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Data:    data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+		b.WriteByte('\n')
+	}
+
+	return b.Bytes(), nil
+}
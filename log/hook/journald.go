@@ -0,0 +1,294 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// JournalSocket is the socket journald receives native protocol messages on.
+const JournalSocket = "/run/systemd/journal/socket"
+
+// journalFieldNames maps logrus fields to journal fields. Other fields are
+// sent with their name converted to a valid journal field name (e.g.
+// "output.file" is sent as OUTPUT_FILE).
+var journalFieldNames = map[string]string{
+	"job.name":     "JOB_NAME",
+	"job.schedule": "JOB_SCHEDULE",
+	"job.command":  "JOB_COMMAND",
+	"job.position": "JOB_POSITION",
+	"iteration":    "JOB_ITERATION",
+	"channel":      "CHANNEL",
+}
+
+// JournalHook sends entries to journald using its native protocol, which
+// keeps fields as journal fields. Entries too large for a datagram are sent
+// through a sealed memfd.
+//
+// Entries are sent in the background, so that a slow or stuck journald never
+// blocks jobs: they are dropped when the queue is full or when they cannot be
+// sent, and drops are reported to stderr at most once a minute.
+type JournalHook struct {
+	addr       *net.UnixAddr
+	identifier string
+	timeout    time.Duration
+
+	mu       sync.Mutex
+	closed   bool
+	messages chan []byte
+	stopped  chan struct{}
+	dropped  uint64
+
+	// Only used by run
+	conn           *net.UnixConn
+	lastErr        error
+	reported       uint64
+	lastReport     time.Time
+	reportInterval time.Duration
+	reportOutput   io.Writer
+}
+
+// NewJournalHook returns a hook sending entries to the journald socket at
+// path (JournalSocket if empty), with identifier as SYSLOG_IDENTIFIER. It
+// fails if there is no socket at path, i.e. if journald is not running. At
+// most 1024 entries wait to be sent.
+func NewJournalHook(path string, identifier string) (*JournalHook, error) {
+	return newJournalHook(path, identifier, 1024)
+}
+
+func newJournalHook(path string, identifier string, queueSize int) (*JournalHook, error) {
+	if path == "" {
+		path = JournalSocket
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("journald socket not found: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("journald socket not found: %s is not a socket", path)
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journald socket: %w", err)
+	}
+
+	h := &JournalHook{
+		addr:           &net.UnixAddr{Name: path, Net: "unixgram"},
+		identifier:     identifier,
+		timeout:        5 * time.Second,
+		messages:       make(chan []byte, queueSize),
+		stopped:        make(chan struct{}),
+		conn:           conn,
+		reportInterval: time.Minute,
+		reportOutput:   os.Stderr,
+	}
+
+	go h.run()
+
+	return h, nil
+}
+
+func (h *JournalHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues entry to be sent, or drops it if the queue is full. It never
+// blocks on journald.
+func (h *JournalHook) Fire(entry *logrus.Entry) error {
+	msg := h.format(entry)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		atomic.AddUint64(&h.dropped, 1)
+		return nil
+	}
+
+	select {
+	case h.messages <- msg:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+	}
+
+	return nil
+}
+
+// Dropped returns the number of entries that were dropped because the queue
+// was full or they could not be sent.
+func (h *JournalHook) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// run sends queued entries until the hook is closed.
+func (h *JournalHook) run() {
+	defer close(h.stopped)
+
+	for msg := range h.messages {
+		if err := h.send(msg); err != nil {
+			atomic.AddUint64(&h.dropped, 1)
+			h.lastErr = err
+		}
+
+		h.report()
+	}
+
+	h.report()
+	h.conn.Close()
+}
+
+// report writes the number of entries dropped since the last report to
+// stderr, at most once per reportInterval.
+func (h *JournalHook) report() {
+	dropped := atomic.LoadUint64(&h.dropped)
+	if dropped == h.reported || time.Since(h.lastReport) < h.reportInterval {
+		return
+	}
+
+	reason := "queue is full"
+	if h.lastErr != nil {
+		reason = h.lastErr.Error()
+	}
+
+	fmt.Fprintf(h.reportOutput, "supercronic: dropped %d logs that could not be sent to journald: %s\n", dropped-h.reported, reason)
+
+	h.reported = dropped
+	h.lastReport = time.Now()
+	h.lastErr = nil
+}
+
+// send sends msg, through a memfd if it is too large for a datagram. It must
+// only be called by run.
+func (h *JournalHook) send(msg []byte) error {
+	h.conn.SetWriteDeadline(time.Now().Add(h.timeout))
+
+	_, _, err := h.conn.WriteMsgUnix(msg, nil, h.addr)
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+
+	file, err := journalMemfd(msg)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, err = h.conn.WriteMsgUnix(nil, syscall.UnixRights(int(file.Fd())), h.addr)
+	return err
+}
+
+// Close stops accepting entries, and waits for the queued ones to be sent,
+// for at most the timeout of the hook.
+func (h *JournalHook) Close() error {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.messages)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-h.stopped:
+	case <-time.After(h.timeout):
+	}
+
+	return nil
+}
+
+func (h *JournalHook) format(entry *logrus.Entry) []byte {
+	var b bytes.Buffer
+
+	severity, ok := syslogSeverities[entry.Level]
+	if !ok {
+		severity = 6
+	}
+
+	writeJournalField(&b, "MESSAGE", entry.Message)
+	writeJournalField(&b, "PRIORITY", fmt.Sprintf("%d", severity))
+	if h.identifier != "" {
+		writeJournalField(&b, "SYSLOG_IDENTIFIER", h.identifier)
+	}
+
+	keys := make([]string, 0, len(entry.Data))
+	for k := range entry.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := entry.Data[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		writeJournalField(&b, journalFieldName(k), fmt.Sprint(v))
+	}
+
+	return b.Bytes()
+}
+
+// writeJournalField writes a field in the journal export format: KEY=value,
+// or the binary form if the value has newlines.
+func writeJournalField(b *bytes.Buffer, key string, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(key + "=" + value + "\n")
+		return
+	}
+
+	b.WriteString(key + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalFieldName turns a logrus field into a valid journal field name: at
+// most 64 uppercase letters, digits and underscores, not starting with an
+// underscore or a digit, and not clashing with the fields set by the hook.
+func journalFieldName(k string) string {
+	if name, ok := journalFieldNames[k]; ok {
+		return name
+	}
+
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, k)
+
+	name = strings.TrimLeft(name, "_")
+
+	switch {
+	case name == "", name[0] >= '0' && name[0] <= '9':
+		name = "FIELD_" + name
+	case name == "MESSAGE", name == "PRIORITY", name == "SYSLOG_IDENTIFIER":
+		name = "FIELD_" + name
+	}
+
+	if len(name) > 64 {
+		name = name[:64]
+	}
+
+	return name
+}
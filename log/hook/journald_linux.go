@@ -0,0 +1,31 @@
+package hook
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// journalMemfd returns a sealed memfd holding msg, for entries too large to
+// be sent as a datagram.
+func journalMemfd(msg []byte) (*os.File, error) {
+	fd, err := unix.MemfdCreate("journal-message", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "journal-message")
+
+	if _, err := file.Write(msg); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	seals := unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_ADD_SEALS, seals); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package hook
+
+import (
+	"errors"
+	"os"
+)
+
+func journalMemfd(msg []byte) (*os.File, error) {
+	return nil, errors.New("entry too large: memfd is only supported on Linux")
+}
@@ -0,0 +1,217 @@
+package hook
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeJournal receives native protocol messages, reading the ones sent
+// through a file descriptor.
+func fakeJournal(t *testing.T) (string, chan map[string]string) {
+	path := filepath.Join(t.TempDir(), "socket")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	messages := make(chan map[string]string, 10)
+
+	go func() {
+		buf := make([]byte, 1024*1024)
+		oob := make([]byte, 1024)
+
+		for {
+			n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+			if err != nil {
+				return
+			}
+
+			msg := buf[:n]
+
+			if oobn > 0 {
+				scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+				if err != nil || len(scms) != 1 {
+					return
+				}
+
+				fds, err := syscall.ParseUnixRights(&scms[0])
+				if err != nil || len(fds) != 1 {
+					return
+				}
+
+				file := os.NewFile(uintptr(fds[0]), "memfd")
+				file.Seek(0, io.SeekStart)
+				msg, err = io.ReadAll(file)
+				file.Close()
+				if err != nil {
+					return
+				}
+			}
+
+			fields, err := parseJournalMessage(msg)
+			if err != nil {
+				return
+			}
+			messages <- fields
+		}
+	}()
+
+	return path, messages
+}
+
+func parseJournalMessage(msg []byte) (map[string]string, error) {
+	fields := make(map[string]string)
+
+	for len(msg) > 0 {
+		i := bytes.IndexAny(msg, "=\n")
+		if i < 0 {
+			return nil, errors.New("invalid message")
+		}
+
+		key := string(msg[:i])
+
+		if msg[i] == '=' {
+			end := bytes.IndexByte(msg, '\n')
+			fields[key] = string(msg[i+1 : end])
+			msg = msg[end+1:]
+			continue
+		}
+
+		msg = msg[i+1:]
+		size := binary.LittleEndian.Uint64(msg[:8])
+		fields[key] = string(msg[8 : 8+size])
+		msg = msg[8+size+1:]
+	}
+
+	return fields, nil
+}
+
+func receiveJournal(t *testing.T, messages chan map[string]string) map[string]string {
+	select {
+	case fields := <-messages:
+		return fields
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for journal message")
+		return nil
+	}
+}
+
+func newJournalTestLogger(t *testing.T, path string) *logrus.Logger {
+	h, err := NewJournalHook(path, "supercronic")
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { h.Close() })
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	return logger
+}
+
+func TestJournalHook(t *testing.T) {
+	path, messages := fakeJournal(t)
+	logger := newJournalTestLogger(t, path)
+
+	logger.WithFields(logrus.Fields{
+		"job.name":     "backup",
+		"job.schedule": "*/5 * * * *",
+		"iteration":    uint64(3),
+		"channel":      "stderr",
+		"output.file":  "/var/log/backup.log",
+		"message":      "clash",
+	}).Warn("line 1\nline 2")
+
+	assert.Equal(t, map[string]string{
+		"MESSAGE":           "line 1\nline 2",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "supercronic",
+		"JOB_NAME":          "backup",
+		"JOB_SCHEDULE":      "*/5 * * * *",
+		"JOB_ITERATION":     "3",
+		"CHANNEL":           "stderr",
+		"OUTPUT_FILE":       "/var/log/backup.log",
+		"FIELD_MESSAGE":     "clash",
+	}, receiveJournal(t, messages))
+
+	logger.WithError(errors.New("boom")).Error("failed")
+
+	fields := receiveJournal(t, messages)
+	assert.Equal(t, "3", fields["PRIORITY"])
+	assert.Equal(t, "boom", fields["ERROR"])
+}
+
+func TestJournalHookLargeEntry(t *testing.T) {
+	path, messages := fakeJournal(t)
+	logger := newJournalTestLogger(t, path)
+
+	large := strings.Repeat("x", 4*1024*1024)
+	logger.Info(large)
+
+	fields := receiveJournal(t, messages)
+	assert.Equal(t, large, fields["MESSAGE"])
+}
+
+func TestNewJournalHookWithoutJournald(t *testing.T) {
+	_, err := NewJournalHook(filepath.Join(t.TempDir(), "socket"), "supercronic")
+	assert.NotNil(t, err)
+
+	path := filepath.Join(t.TempDir(), "file")
+	assert.Nil(t, os.WriteFile(path, nil, 0644))
+	_, err = NewJournalHook(path, "supercronic")
+	assert.NotNil(t, err)
+}
+
+func TestJournalHookDropsWhenQueueIsFull(t *testing.T) {
+	// A journal that never reads its socket
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	h, err := newJournalHook(path, "supercronic", 1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	h.timeout = 100 * time.Millisecond
+	h.reportOutput = io.Discard
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	start := time.Now()
+	for i := 0; i < 64; i++ {
+		logger.Info(strings.Repeat("x", 64*1024))
+	}
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	assert.True(t, h.Dropped() > 0)
+
+	h.Close()
+}
+
+func TestJournalFieldName(t *testing.T) {
+	assert.Equal(t, "LINE_NUMBER", journalFieldName("line.number"))
+	assert.Equal(t, "FIELD_1ST", journalFieldName("1st"))
+	assert.Equal(t, "HIDDEN", journalFieldName("_hidden"))
+	assert.Equal(t, "FIELD_", journalFieldName("é"))
+	assert.Len(t, journalFieldName(strings.Repeat("a", 100)), 64)
+}
@@ -0,0 +1,62 @@
+package hook
+
+import (
+	"errors"
+
+	"github.com/aptible/supercronic/redact"
+	"github.com/sirupsen/logrus"
+)
+
+type redactHook struct {
+	redactor *redact.Redactor
+}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redactor.Redact(entry.Message)
+
+	for k, v := range entry.Data {
+		entry.Data[k] = h.redact(v)
+	}
+
+	return nil
+}
+
+// redact masks secrets in v, walking the maps and slices that come from
+// JSON job output.
+func (h *redactHook) redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return h.redactor.Redact(v)
+	case []string:
+		return h.redactor.RedactAll(v)
+	case error:
+		if msg := h.redactor.Redact(v.Error()); msg != v.Error() {
+			return errors.New(msg)
+		}
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			redacted[k] = h.redact(value)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, value := range v {
+			redacted[i] = h.redact(value)
+		}
+		return redacted
+	}
+
+	return v
+}
+
+// RegisterRedactor masks secrets in every entry logged by logger, including
+// what other hooks (e.g. Sentry) see. It must be registered before any other
+// hook.
+func RegisterRedactor(logger *logrus.Logger, redactor *redact.Redactor) {
+	logger.AddHook(&redactHook{redactor: redactor})
+}
@@ -0,0 +1,93 @@
+package hook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/redact"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type captureHook struct {
+	entries chan *logrus.Entry
+}
+
+func (h *captureHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *captureHook) Fire(entry *logrus.Entry) error {
+	h.entries <- entry
+	return nil
+}
+
+func TestRedactHook_Fire(t *testing.T) {
+	out := testWriter{c: make(chan []byte, 1)}
+
+	log := logrus.New()
+	log.SetOutput(out)
+
+	r := redact.New(nil)
+	r.SetSecrets([]string{"hunter2"})
+
+	RegisterRedactor(log, r)
+
+	capture := &captureHook{entries: make(chan *logrus.Entry, 1)}
+	log.AddHook(capture)
+
+	log.WithFields(logrus.Fields{
+		"job.command":   "login -p hunter2",
+		logrus.ErrorKey: errors.New("bad password hunter2"),
+		"count":         1,
+	}).Error("output: hunter2")
+
+	select {
+	case entry := <-capture.entries:
+		assert.Equal(t, "output: [REDACTED]", entry.Message)
+		assert.Equal(t, "login -p [REDACTED]", entry.Data["job.command"])
+		assert.EqualError(t, entry.Data[logrus.ErrorKey].(error), "bad password [REDACTED]")
+		assert.Equal(t, 1, entry.Data["count"])
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for entry")
+	}
+
+	select {
+	case line := <-out.c:
+		assert.NotContains(t, string(line), "hunter2")
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for log")
+	}
+}
+
+func TestRedactHook_FireNestedFields(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(testWriter{c: make(chan []byte, 1)})
+
+	r := redact.New(nil)
+	r.SetSecrets([]string{"hunter2"})
+
+	RegisterRedactor(log, r)
+
+	capture := &captureHook{entries: make(chan *logrus.Entry, 1)}
+	log.AddHook(capture)
+
+	// Fields merged from JSON job output
+	log.WithFields(logrus.Fields{
+		"request": map[string]interface{}{
+			"headers": map[string]interface{}{"authorization": "Bearer hunter2"},
+			"args":    []interface{}{"--password", "hunter2", 3.0},
+		},
+	}).Info("done")
+
+	select {
+	case entry := <-capture.entries:
+		assert.Equal(t, map[string]interface{}{
+			"headers": map[string]interface{}{"authorization": "Bearer [REDACTED]"},
+			"args":    []interface{}{"--password", "[REDACTED]", 3.0},
+		}, entry.Data["request"])
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for entry")
+	}
+}
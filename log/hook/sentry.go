@@ -0,0 +1,164 @@
+package hook
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// ExitCodeField is the field holding the exit code of a failed job.
+const ExitCodeField = "exit_code"
+
+var sentryLevels = map[logrus.Level]sentry.Level{
+	logrus.PanicLevel: sentry.LevelFatal,
+	logrus.FatalLevel: sentry.LevelFatal,
+	logrus.ErrorLevel: sentry.LevelError,
+	logrus.WarnLevel:  sentry.LevelWarning,
+	logrus.InfoLevel:  sentry.LevelInfo,
+	logrus.DebugLevel: sentry.LevelDebug,
+	logrus.TraceLevel: sentry.LevelDebug,
+}
+
+// SentryHook reports entries to Sentry. For entries about a job, it adds the
+// job name, schedule and exit code as tags, and fingerprints them per job
+// and failure type, so that failures of different jobs are not grouped in
+// one issue. It also drops the entries of jobs that opted out, and limits
+// the number of reports sent for each job. All fields are sent in the
+// "supercronic" context.
+type SentryHook struct {
+	client *sentry.Client
+	levels []logrus.Level
+	limit  int
+
+	mu      sync.Mutex
+	optOut  map[string]bool
+	buckets map[string]*sentryBucket
+	now     func() time.Time
+}
+
+type sentryBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewSentryHook reports the entries at levels to client, sending at most
+// limit reports per hour for each job (0 for no limit).
+func NewSentryHook(client *sentry.Client, levels []logrus.Level, limit int) *SentryHook {
+	return &SentryHook{
+		client:  client,
+		levels:  levels,
+		limit:   limit,
+		optOut:  make(map[string]bool),
+		buckets: make(map[string]*sentryBucket),
+		now:     time.Now,
+	}
+}
+
+// SetOptOut sets the names of the jobs whose entries are not reported.
+func (h *SentryHook) SetOptOut(names []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.optOut = make(map[string]bool, len(names))
+	for _, name := range names {
+		h.optOut[name] = true
+	}
+}
+
+func (h *SentryHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *SentryHook) Fire(entry *logrus.Entry) error {
+	name, isJob := entry.Data["job.name"].(string)
+
+	if isJob && !h.allow(name) {
+		return nil
+	}
+
+	event := sentry.NewEvent()
+	event.Level = sentryLevels[entry.Level]
+	event.Message = entry.Message
+	event.Timestamp = entry.Time
+	event.Logger = "supercronic"
+
+	fields := sentry.Context{}
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			if k == logrus.ErrorKey {
+				event.Exception = []sentry.Exception{{Type: fmt.Sprintf("%T", err), Value: err.Error()}}
+				continue
+			}
+			v = err.Error()
+		}
+		fields[k] = v
+	}
+
+	if len(fields) > 0 {
+		event.Contexts["supercronic"] = fields
+	}
+
+	if isJob {
+		event.Tags["job.name"] = name
+		if schedule, ok := entry.Data["job.schedule"].(string); ok {
+			event.Tags["job.schedule"] = schedule
+		}
+
+		failure := entry.Message
+		if exitCode, ok := entry.Data[ExitCodeField]; ok {
+			event.Tags[ExitCodeField] = fmt.Sprint(exitCode)
+			failure = fmt.Sprintf("exit code %v", exitCode)
+		}
+
+		event.Fingerprint = []string{"supercronic", name, failure}
+	}
+
+	h.client.CaptureEvent(event, nil, nil)
+
+	return nil
+}
+
+// Flush waits until the reports are sent, or timeout.
+func (h *SentryHook) Flush(timeout time.Duration) bool {
+	return h.client.Flush(timeout)
+}
+
+// allow reports whether an entry of the job can be reported.
+func (h *SentryHook) allow(name string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.optOut[name] {
+		return false
+	}
+
+	if h.limit <= 0 {
+		return true
+	}
+
+	now := h.now()
+	limit := float64(h.limit)
+
+	bucket, ok := h.buckets[name]
+	if !ok {
+		bucket = &sentryBucket{tokens: limit, last: now}
+		h.buckets[name] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Hours() * limit
+	if bucket.tokens > limit {
+		bucket.tokens = limit
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+
+	bucket.tokens--
+
+	return true
+}
@@ -0,0 +1,166 @@
+package hook
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSentry is a Sentry endpoint that returns the items of the envelopes it
+// receives.
+func fakeSentry(t *testing.T) (string, chan map[string]interface{}) {
+	items := make(chan map[string]interface{}, 100)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+
+		// Skip the envelope header, then read item headers and
+		// payloads
+		scanner.Scan()
+		for scanner.Scan() {
+			var header map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || !scanner.Scan() {
+				break
+			}
+
+			var payload map[string]interface{}
+			if err := json.Unmarshal(bytes.TrimSpace(scanner.Bytes()), &payload); err != nil {
+				continue
+			}
+			payload["_type"] = header["type"]
+			items <- payload
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.Replace(srv.URL, "http://", "http://public@", 1) + "/1", items
+}
+
+func receiveSentry(t *testing.T, items chan map[string]interface{}) map[string]interface{} {
+	select {
+	case item := <-items:
+		return item
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for Sentry event")
+		return nil
+	}
+}
+
+func newSentryTestLogger(t *testing.T, limit int) (*logrus.Logger, *SentryHook, chan map[string]interface{}) {
+	dsn, items := fakeSentry(t)
+
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: dsn})
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	h := NewSentryHook(client, []logrus.Level{logrus.ErrorLevel}, limit)
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	return logger, h, items
+}
+
+func TestSentryHookEnrichesJobEntries(t *testing.T) {
+	logger, h, items := newSentryTestLogger(t, 0)
+
+	jobLogger := logger.WithFields(logrus.Fields{
+		"job.name":     "backup",
+		"job.schedule": "0 * * * *",
+		"iteration":    3,
+	})
+
+	jobLogger.WithFields(logrus.Fields{"exit_code": 2, "output": "oops"}).Error("error running command: exit status 2")
+	h.Flush(time.Second)
+
+	event := receiveSentry(t, items)
+	assert.Equal(t, "error running command: exit status 2", event["message"])
+	assert.Equal(t, "error", event["level"])
+	assert.Equal(t, map[string]interface{}{
+		"job.name":     "backup",
+		"job.schedule": "0 * * * *",
+		"exit_code":    "2",
+	}, event["tags"])
+	assert.Equal(t, []interface{}{"supercronic", "backup", "exit code 2"}, event["fingerprint"])
+
+	fields := event["contexts"].(map[string]interface{})["supercronic"].(map[string]interface{})
+	assert.Equal(t, float64(3), fields["iteration"])
+	assert.Equal(t, "oops", fields["output"])
+
+	jobLogger.Error("failed to close pipe")
+	h.Flush(time.Second)
+
+	event = receiveSentry(t, items)
+	assert.Equal(t, []interface{}{"supercronic", "backup", "failed to close pipe"}, event["fingerprint"])
+
+	logger.Info("not reported")
+	logger.Error("not a job")
+	h.Flush(time.Second)
+
+	event = receiveSentry(t, items)
+	assert.Equal(t, "not a job", event["message"])
+	assert.NotContains(t, event, "fingerprint")
+}
+
+func TestSentryHookOptOut(t *testing.T) {
+	logger, h, items := newSentryTestLogger(t, 0)
+	h.SetOptOut([]string{"noisy"})
+
+	logger.WithField("job.name", "noisy").Error("oops")
+	logger.WithField("job.name", "backup").Error("oops")
+	h.Flush(time.Second)
+
+	event := receiveSentry(t, items)
+	assert.Equal(t, "backup", event["tags"].(map[string]interface{})["job.name"])
+	assert.Empty(t, items)
+}
+
+func TestSentryHookRateLimit(t *testing.T) {
+	logger, h, items := newSentryTestLogger(t, 2)
+
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		logger.WithField("job.name", "flaky").Error("oops")
+	}
+	logger.WithField("job.name", "backup").Error("oops")
+	h.Flush(time.Second)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, items, 3)
+
+	// Half an hour later, one more report can be sent
+	now = now.Add(30 * time.Minute)
+	for i := 0; i < 5; i++ {
+		logger.WithField("job.name", "flaky").Error("oops")
+	}
+	h.Flush(time.Second)
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, items, 4)
+}
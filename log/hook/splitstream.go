@@ -1,47 +1,199 @@
 package hook
 
 import (
+	"fmt"
 	"io"
+	"os"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-type writerHook struct {
-	writer io.Writer
-	levels []logrus.Level
+// Route sends the entries at one of Levels to Writers. If Channel is set,
+// only job output logged on that channel (e.g. "stderr") matches the route.
+// Default routes only apply to the entries that match no other route.
+type Route struct {
+	Levels  []logrus.Level
+	Channel string
+	Writers []io.Writer
+	Default bool
 }
 
-func (h *writerHook) Levels() []logrus.Level {
-	return h.levels
+func (r *Route) matches(entry *logrus.Entry) bool {
+	if r.Channel != "" {
+		if channel, _ := entry.Data["channel"].(string); channel != r.Channel {
+			return false
+		}
+	}
+
+	for _, level := range r.Levels {
+		if level == entry.Level {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SplitRoutes returns the default routes of split logs: debug and info to
+// outWriter, and warn and above to errWriter.
+func SplitRoutes(outWriter io.Writer, errWriter io.Writer) []Route {
+	return []Route{
+		{
+			Levels:  []logrus.Level{logrus.TraceLevel, logrus.DebugLevel, logrus.InfoLevel},
+			Writers: []io.Writer{outWriter},
+			Default: true,
+		},
+		{
+			Levels:  []logrus.Level{logrus.WarnLevel, logrus.ErrorLevel, logrus.FatalLevel, logrus.PanicLevel},
+			Writers: []io.Writer{errWriter},
+			Default: true,
+		},
+	}
+}
+
+// ParseRoutes parses routes of the form SELECTOR=DESTINATION[,DESTINATION...].
+// SELECTOR is a level (e.g. "info"), a level and above (e.g. "error+"), a
+// job output channel (e.g. "channel:stderr"), or "*" for all entries.
+// DESTINATION is stdout, stderr, discard, or file:PATH (opened in append
+// mode, and shared by all the routes using it).
+func ParseRoutes(specs []string, stdout io.Writer, stderr io.Writer) ([]Route, error) {
+	routes := make([]Route, 0, len(specs))
+	files := make(map[string]io.Writer)
+
+	for _, spec := range specs {
+		selector, destinations, ok := strings.Cut(spec, "=")
+		if !ok || destinations == "" {
+			return nil, fmt.Errorf("invalid log route: %q (expected SELECTOR=DESTINATION)", spec)
+		}
+
+		route := Route{Levels: logrus.AllLevels}
+
+		switch {
+		case selector == "*":
+		case strings.HasPrefix(selector, "channel:"):
+			route.Channel = strings.TrimPrefix(selector, "channel:")
+			if route.Channel == "" {
+				return nil, fmt.Errorf("invalid log route: %q: missing channel", spec)
+			}
+		default:
+			name := strings.TrimSuffix(selector, "+")
+
+			level, err := logrus.ParseLevel(name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid log route: %q: %w", spec, err)
+			}
+
+			route.Levels = []logrus.Level{level}
+			if strings.HasSuffix(selector, "+") {
+				route.Levels = nil
+				for _, l := range logrus.AllLevels {
+					if l <= level {
+						route.Levels = append(route.Levels, l)
+					}
+				}
+			}
+		}
+
+		for _, destination := range strings.Split(destinations, ",") {
+			switch {
+			case destination == "stdout":
+				route.Writers = append(route.Writers, stdout)
+			case destination == "stderr":
+				route.Writers = append(route.Writers, stderr)
+			case destination == "discard":
+			case strings.HasPrefix(destination, "file:"):
+				path := strings.TrimPrefix(destination, "file:")
+
+				file, ok := files[path]
+				if !ok {
+					f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+					if err != nil {
+						return nil, fmt.Errorf("invalid log route: %q: %w", spec, err)
+					}
+					file = f
+					files[path] = file
+				}
+
+				route.Writers = append(route.Writers, file)
+			default:
+				return nil, fmt.Errorf("invalid log route: %q: unknown destination %q", spec, destination)
+			}
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes, nil
 }
 
-func (h *writerHook) Fire(entry *logrus.Entry) error {
+type routingHook struct {
+	routes []Route
+}
+
+func (h *routingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *routingHook) Fire(entry *logrus.Entry) error {
+	writers, matched := h.writers(entry, false)
+	if !matched {
+		writers, _ = h.writers(entry, true)
+	}
+
+	if len(writers) == 0 {
+		return nil
+	}
+
 	serialized, err := entry.Logger.Formatter.Format(entry)
 	if err != nil {
 		return err
 	}
-	_, err = h.writer.Write(serialized)
-	return err
+
+	for _, w := range writers {
+		if _, err := w.Write(serialized); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writers returns the writers of all the default (or non-default) routes
+// entry matches, each once, and whether it matched any.
+func (h *routingHook) writers(entry *logrus.Entry, defaults bool) ([]io.Writer, bool) {
+	var writers []io.Writer
+	matched := false
+
+	for _, route := range h.routes {
+		if route.Default != defaults || !route.matches(entry) {
+			continue
+		}
+		matched = true
+
+	writer:
+		for _, w := range route.Writers {
+			for _, seen := range writers {
+				if w == seen {
+					continue writer
+				}
+			}
+			writers = append(writers, w)
+		}
+	}
+
+	return writers, matched
 }
 
-func RegisterSplitLogger(logger *logrus.Logger, outWriter io.Writer, errWriter io.Writer) {
+// RegisterRoutedLogger writes the entries logged by logger to the writers of
+// every route they match, instead of the output of the logger. Entries that
+// match no route but default ones go to the writers of the default routes
+// they match, and entries that match no route at all are dropped.
+func RegisterRoutedLogger(logger *logrus.Logger, routes []Route) {
 	logger.SetOutput(io.Discard)
+	logger.AddHook(&routingHook{routes: routes})
+}
 
-	logger.AddHook(&writerHook{
-		writer: outWriter,
-		levels: []logrus.Level{
-			logrus.DebugLevel,
-			logrus.InfoLevel,
-		},
-	})
-
-	logger.AddHook(&writerHook{
-		writer: errWriter,
-		levels: []logrus.Level{
-			logrus.WarnLevel,
-			logrus.ErrorLevel,
-			logrus.FatalLevel,
-			logrus.PanicLevel,
-		},
-	})
+func RegisterSplitLogger(logger *logrus.Logger, outWriter io.Writer, errWriter io.Writer) {
+	RegisterRoutedLogger(logger, SplitRoutes(outWriter, errWriter))
 }
@@ -1,8 +1,13 @@
 package hook
 
 import (
+	"bytes"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -67,3 +72,116 @@ func TestSplitStdoutStreamHook_Fire(t *testing.T) {
 		// Noop
 	}
 }
+
+func newRoutedTestLogger(t *testing.T, specs []string, stdout *bytes.Buffer, stderr *bytes.Buffer) *logrus.Logger {
+	routes, err := ParseRoutes(specs, stdout, stderr)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.DebugLevel)
+	log.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true, DisableColors: true})
+
+	RegisterRoutedLogger(log, append(routes, SplitRoutes(stdout, stderr)...))
+
+	return log
+}
+
+func TestRoutedLoggerChannel(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	log := newRoutedTestLogger(t, []string{"channel:stderr=stderr"}, &stdout, &stderr)
+
+	log.WithField("channel", "stderr").Info("job err")
+	log.WithField("channel", "stdout").Info("job out")
+	log.Info("info")
+	log.Warn("warn")
+
+	assert.Contains(t, stderr.String(), "job err")
+	assert.Contains(t, stderr.String(), "warn")
+	assert.NotContains(t, stdout.String(), "job err")
+
+	assert.Contains(t, stdout.String(), "job out")
+	assert.Contains(t, stdout.String(), "info")
+}
+
+func TestRoutedLoggerFansOut(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	path := filepath.Join(t.TempDir(), "all.log")
+	log := newRoutedTestLogger(t, []string{
+		"*=file:" + path,
+		"error+=stderr,file:" + path,
+		"channel:stderr=stderr",
+	}, &stdout, &stderr)
+
+	log.Info("info")
+	log.WithField("channel", "stderr").Error("job err")
+
+	// Logs that match any route skip the default routes
+	assert.Equal(t, "", stdout.String())
+
+	// Every matching route applies, but each destination gets a log once
+	assert.Equal(t, `level=error msg="job err" channel=stderr`+"\n", stderr.String())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, `level=info msg=info`+"\n"+`level=error msg="job err" channel=stderr`+"\n", string(content))
+}
+
+func TestRoutedLoggerLevelsAndFile(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	path := filepath.Join(t.TempDir(), "errors.log")
+	log := newRoutedTestLogger(t, []string{
+		"error+=stderr,file:" + path,
+		"debug=discard",
+		"warn=file:" + path,
+	}, &stdout, &stderr)
+
+	log.Debug("debug")
+	log.Info("info")
+	log.Warn("warn")
+	log.Error("error")
+
+	assert.Equal(t, `level=info msg=info`+"\n", stdout.String())
+	assert.Equal(t, `level=error msg=error`+"\n", stderr.String())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"level=warning msg=warn", "level=error msg=error"}, strings.Split(strings.TrimSpace(string(content)), "\n"))
+}
+
+func TestRoutedLoggerUnmatchedEntriesAreDropped(t *testing.T) {
+	var out bytes.Buffer
+
+	log := logrus.New()
+	log.SetOutput(&out)
+	RegisterRoutedLogger(log, []Route{{Levels: []logrus.Level{logrus.ErrorLevel}, Writers: []io.Writer{&out}}})
+
+	log.Info("info")
+	log.Error("error")
+
+	assert.NotContains(t, out.String(), "info")
+	assert.Contains(t, out.String(), "error")
+}
+
+func TestParseRoutes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	routes, err := ParseRoutes([]string{"*=stdout", "warn+=stderr,stdout", "channel:stdout=discard"}, &stdout, &stderr)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, logrus.AllLevels, routes[0].Levels)
+	assert.Equal(t, []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}, routes[1].Levels)
+	assert.Len(t, routes[1].Writers, 2)
+	assert.Equal(t, "stdout", routes[2].Channel)
+	assert.Empty(t, routes[2].Writers)
+
+	for _, spec := range []string{"info", "info=", "nope=stdout", "channel:=stdout", "info=syslog", "info=file:/nonexistent/dir/file"} {
+		_, err := ParseRoutes([]string{spec}, &stdout, &stderr)
+		assert.NotNil(t, err, spec)
+	}
+}
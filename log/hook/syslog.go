@@ -0,0 +1,433 @@
+package hook
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// ParseSyslogFacility returns the code of a syslog facility from its name
+// (e.g. "cron" or "local0").
+func ParseSyslogFacility(s string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility: %q", s)
+	}
+
+	return facility, nil
+}
+
+// syslogSeverities maps logrus levels to RFC 5424 severities.
+var syslogSeverities = map[logrus.Level]int{
+	logrus.PanicLevel: 1, // alert
+	logrus.FatalLevel: 2, // critical
+	logrus.ErrorLevel: 3, // error
+	logrus.WarnLevel:  4, // warning
+	logrus.InfoLevel:  6, // informational
+	logrus.DebugLevel: 7, // debug
+	logrus.TraceLevel: 7, // debug
+}
+
+// SyslogEnterpriseID is the private enterprise number used in the IDs of the
+// structured data elements. 32473 is reserved for documentation and examples
+// by RFC 5612.
+const SyslogEnterpriseID = "32473"
+
+// SyslogConfig configures a SyslogHook. URL is the address of the syslog
+// server: udp://HOST:PORT, tcp://HOST:PORT, tls://HOST:PORT or
+// unixgram:///PATH (e.g. unixgram:///dev/log). TLS is used for tls:// URLs,
+// and defaults to verifying the server against the system roots. Hostname
+// defaults to the host name of the machine. QueueSize is the number of
+// messages waiting to be sent beyond which new ones are dropped (1024 by
+// default).
+type SyslogConfig struct {
+	URL       string
+	Facility  int
+	AppName   string
+	Hostname  string
+	TLS       *tls.Config
+	Timeout   time.Duration
+	QueueSize int
+}
+
+// SyslogHook sends entries to a syslog server as RFC 5424 messages. Job
+// fields are sent as the "job" structured data element, and other fields as
+// the "fields" element. Messages sent over TCP are framed with octet
+// counting (RFC 6587).
+//
+// Messages are sent in the background, so that a slow or missing server never
+// blocks jobs: they are dropped when the queue is full or when they cannot be
+// sent, and drops are reported to stderr at most once a minute.
+type SyslogHook struct {
+	network  string
+	address  string
+	tls      *tls.Config
+	facility int
+	appName  string
+	hostname string
+	timeout  time.Duration
+	procID   string
+
+	mu       sync.Mutex
+	closed   bool
+	messages chan []byte
+	stopped  chan struct{}
+	dropped  uint64
+
+	// Only used by run
+	conn           net.Conn
+	lastErr        error
+	reported       uint64
+	lastReport     time.Time
+	reportInterval time.Duration
+	reportOutput   io.Writer
+}
+
+func NewSyslogHook(config SyslogConfig) (*SyslogHook, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address: %w", err)
+	}
+
+	h := &SyslogHook{
+		facility: config.Facility,
+		appName:  syslogHeaderField(config.AppName, 48),
+		hostname: syslogHeaderField(config.Hostname, 255),
+		timeout:  config.Timeout,
+		procID:   fmt.Sprintf("%d", os.Getpid()),
+
+		reportInterval: time.Minute,
+		reportOutput:   os.Stderr,
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		h.network = u.Scheme
+		h.address = u.Host
+	case "tls":
+		h.network = "tcp"
+		h.address = u.Host
+		h.tls = config.TLS
+		if h.tls == nil {
+			h.tls = &tls.Config{}
+		}
+	case "unixgram", "unix":
+		h.network = "unixgram"
+		h.address = u.Path
+	default:
+		return nil, fmt.Errorf("invalid syslog address: unsupported scheme %q", u.Scheme)
+	}
+
+	if h.address == "" {
+		return nil, fmt.Errorf("invalid syslog address: %q", config.URL)
+	}
+
+	if h.facility < 0 || h.facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility: %d", h.facility)
+	}
+
+	if h.hostname == "-" {
+		if hostname, err := os.Hostname(); err == nil {
+			h.hostname = syslogHeaderField(hostname, 255)
+		}
+	}
+
+	if h.timeout == 0 {
+		h.timeout = 5 * time.Second
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = 1024
+	}
+
+	h.messages = make(chan []byte, config.QueueSize)
+	h.stopped = make(chan struct{})
+
+	go h.run()
+
+	return h, nil
+}
+
+func (h *SyslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire queues entry to be sent, or drops it if the queue is full. It never
+// blocks on the network.
+func (h *SyslogHook) Fire(entry *logrus.Entry) error {
+	msg := h.format(entry)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		atomic.AddUint64(&h.dropped, 1)
+		return nil
+	}
+
+	select {
+	case h.messages <- msg:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+	}
+
+	return nil
+}
+
+// Dropped returns the number of messages that were dropped because the
+// queue was full or they could not be sent.
+func (h *SyslogHook) Dropped() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// run sends queued messages until the hook is closed.
+func (h *SyslogHook) run() {
+	defer close(h.stopped)
+
+	for msg := range h.messages {
+		// Retry once, in case the server closed the connection since the
+		// last message
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			if err = h.write(msg); err == nil {
+				break
+			}
+		}
+
+		if err != nil {
+			atomic.AddUint64(&h.dropped, 1)
+			h.lastErr = err
+		}
+
+		h.report()
+	}
+
+	h.report()
+
+	if h.conn != nil {
+		h.conn.Close()
+		h.conn = nil
+	}
+}
+
+// report writes the number of messages dropped since the last report to
+// stderr, at most once per reportInterval. It cannot use logrus, whose
+// entries would come back to this hook.
+func (h *SyslogHook) report() {
+	dropped := atomic.LoadUint64(&h.dropped)
+	if dropped == h.reported || time.Since(h.lastReport) < h.reportInterval {
+		return
+	}
+
+	reason := "queue is full"
+	if h.lastErr != nil {
+		reason = h.lastErr.Error()
+	}
+
+	fmt.Fprintf(h.reportOutput, "supercronic: dropped %d logs that could not be sent to syslog: %s\n", dropped-h.reported, reason)
+
+	h.reported = dropped
+	h.lastReport = time.Now()
+	h.lastErr = nil
+}
+
+// write sends msg, connecting to the server first if needed. It must only be
+// called by run.
+func (h *SyslogHook) write(msg []byte) error {
+	if h.conn == nil {
+		dialer := &net.Dialer{Timeout: h.timeout}
+
+		var conn net.Conn
+		var err error
+		if h.tls != nil {
+			conn, err = tls.DialWithDialer(dialer, h.network, h.address, h.tls)
+		} else {
+			conn, err = dialer.Dial(h.network, h.address)
+		}
+		if err != nil {
+			return err
+		}
+
+		h.conn = conn
+	}
+
+	if h.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	h.conn.SetWriteDeadline(time.Now().Add(h.timeout))
+
+	if _, err := h.conn.Write(msg); err != nil {
+		h.conn.Close()
+		h.conn = nil
+		return err
+	}
+
+	return nil
+}
+
+// Close stops accepting entries, and waits for the queued ones to be sent,
+// for at most the timeout of the hook.
+func (h *SyslogHook) Close() error {
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.messages)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-h.stopped:
+	case <-time.After(h.timeout):
+	}
+
+	return nil
+}
+
+func (h *SyslogHook) format(entry *logrus.Entry) []byte {
+	severity, ok := syslogSeverities[entry.Level]
+	if !ok {
+		severity = 6
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(
+		&b,
+		"<%d>1 %s %s %s %s - ",
+		h.facility*8+severity,
+		entry.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		h.hostname,
+		h.appName,
+		h.procID,
+	)
+
+	job := map[string]interface{}{}
+	fields := map[string]interface{}{}
+
+	for k, v := range entry.Data {
+		switch {
+		case strings.HasPrefix(k, "job."):
+			job[strings.TrimPrefix(k, "job.")] = v
+		case k == "iteration" || k == "channel":
+			job[k] = v
+		default:
+			fields[k] = v
+		}
+	}
+
+	if len(job) == 0 && len(fields) == 0 {
+		b.WriteString("-")
+	}
+	writeSyslogElement(&b, "job", job)
+	writeSyslogElement(&b, "fields", fields)
+
+	b.WriteString(" ")
+	b.WriteString(entry.Message)
+
+	return []byte(b.String())
+}
+
+func writeSyslogElement(b *strings.Builder, name string, params map[string]interface{}) {
+	if len(params) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b.WriteString("[" + name + "@" + SyslogEnterpriseID)
+
+	for _, k := range keys {
+		v := params[k]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+
+		b.WriteString(" " + syslogParamName(k) + `="`)
+		b.WriteString(syslogParamEscaper.Replace(fmt.Sprint(v)))
+		b.WriteString(`"`)
+	}
+
+	b.WriteString("]")
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogParamName turns a field name into a valid PARAM-NAME: at most 32
+// printable ASCII characters, except '=', ' ', ']' and '"'.
+func syslogParamName(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	if name == "" {
+		name = "_"
+	}
+
+	return name
+}
+
+// syslogHeaderField turns s into a valid header field of at most max
+// printable ASCII characters, or "-" (the nil value) if s is empty.
+func syslogHeaderField(s string, max int) string {
+	field := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+
+	if len(field) > max {
+		field = field[:max]
+	}
+
+	if field == "" {
+		return "-"
+	}
+
+	return field
+}
@@ -0,0 +1,355 @@
+package hook
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSyslogTestLogger(t *testing.T, config SyslogConfig) *logrus.Logger {
+	config.AppName = "supercronic"
+	config.Hostname = "myhost"
+	config.Facility = 9
+
+	h, err := NewSyslogHook(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { h.Close() })
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	return logger
+}
+
+func readDatagrams(conn net.PacketConn) chan string {
+	messages := make(chan string, 10)
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+
+	return messages
+}
+
+// readOctetCounted reads RFC 6587 framed messages from the connections
+// accepted by listener.
+func readOctetCounted(listener net.Listener) chan string {
+	messages := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+
+					n, err := strconv.Atoi(strings.TrimSpace(length))
+					if err != nil {
+						return
+					}
+
+					msg := make([]byte, n)
+					if _, err := io.ReadFull(r, msg); err != nil {
+						return
+					}
+					messages <- string(msg)
+				}
+			}()
+		}
+	}()
+
+	return messages
+}
+
+func receive(t *testing.T, messages chan string) string {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatalf("timed out waiting for syslog message")
+		return ""
+	}
+}
+
+func assertSyslogMessage(t *testing.T, msg string, pri int, sd string, text string) {
+	parts := strings.SplitN(msg, " ", 7)
+	if !assert.Len(t, parts, 7, msg) {
+		return
+	}
+
+	assert.Equal(t, fmt.Sprintf("<%d>1", pri), parts[0])
+	_, err := time.Parse(time.RFC3339Nano, parts[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "myhost", parts[2])
+	assert.Equal(t, "supercronic", parts[3])
+	assert.Equal(t, "-", parts[5])
+	assert.Equal(t, sd+" "+text, parts[6])
+}
+
+func TestSyslogHookUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	messages := readDatagrams(conn)
+
+	logger := newSyslogTestLogger(t, SyslogConfig{URL: "udp://" + conn.LocalAddr().String()})
+
+	logger.WithFields(logrus.Fields{
+		"job.name":     "backup",
+		"job.schedule": "*/5 * * * *",
+		"job.command":  `echo "a]b"`,
+		"iteration":    3,
+		"channel":      "stdout",
+		"line.number":  uint64(7),
+	}).Warn("hello")
+
+	assertSyslogMessage(
+		t,
+		receive(t, messages),
+		9*8+4,
+		`[job@32473 channel="stdout" command="echo \"a\]b\"" iteration="3" name="backup" schedule="*/5 * * * *"][fields@32473 line.number="7"]`,
+		"hello",
+	)
+
+	logger.Info("no fields")
+	assertSyslogMessage(t, receive(t, messages), 9*8+6, "-", "no fields")
+
+	logger.WithError(errors.New("boom")).Error("failed")
+	assertSyslogMessage(t, receive(t, messages), 9*8+3, `[fields@32473 error="boom"]`, "failed")
+}
+
+func TestSyslogHookUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	conn, err := net.ListenPacket("unixgram", path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	messages := readDatagrams(conn)
+
+	logger := newSyslogTestLogger(t, SyslogConfig{URL: "unixgram://" + path})
+	logger.Info("hello")
+
+	assertSyslogMessage(t, receive(t, messages), 9*8+6, "-", "hello")
+}
+
+func TestSyslogHookTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer listener.Close()
+	messages := readOctetCounted(listener)
+
+	logger := newSyslogTestLogger(t, SyslogConfig{URL: "tcp://" + listener.Addr().String()})
+	logger.Info("hello")
+	logger.Info("world")
+
+	assertSyslogMessage(t, receive(t, messages), 9*8+6, "-", "hello")
+	assertSyslogMessage(t, receive(t, messages), 9*8+6, "-", "world")
+}
+
+func TestSyslogHookTLS(t *testing.T) {
+	cert, pool := newTestCertificate(t)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer listener.Close()
+	messages := readOctetCounted(listener)
+
+	logger := newSyslogTestLogger(t, SyslogConfig{
+		URL: "tls://" + listener.Addr().String(),
+		TLS: &tls.Config{RootCAs: pool},
+	})
+	logger.Info("hello")
+
+	assertSyslogMessage(t, receive(t, messages), 9*8+6, "-", "hello")
+}
+
+func TestSyslogHookDoesNotBlockWhenServerIsDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	h, err := NewSyslogHook(SyslogConfig{URL: "tcp://" + address, Timeout: 100 * time.Millisecond})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var report bytes.Buffer
+	h.reportInterval = time.Hour
+	h.reportOutput = &report
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		logger.Info("hello")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+
+	h.Close()
+
+	assert.Equal(t, uint64(3), h.Dropped())
+
+	// Failures are reported once per interval, not for every message
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], "dropped 1 logs that could not be sent to syslog")
+	}
+}
+
+func TestSyslogHookDropsWhenQueueIsFull(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer listener.Close()
+
+	// Accept connections, but never read from them
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	h, err := NewSyslogHook(SyslogConfig{
+		URL:       "tcp://" + listener.Addr().String(),
+		Timeout:   100 * time.Millisecond,
+		QueueSize: 1,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	h.reportOutput = io.Discard
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.AddHook(h)
+
+	large := strings.Repeat("x", 1<<20)
+
+	start := time.Now()
+	for i := 0; i < 32; i++ {
+		logger.Info(large)
+	}
+	assert.True(t, time.Since(start) < 2*time.Second)
+
+	assert.True(t, h.Dropped() > 0)
+
+	h.Close()
+}
+
+func TestSyslogHookClosed(t *testing.T) {
+	h, err := NewSyslogHook(SyslogConfig{URL: "udp://127.0.0.1:514"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Nil(t, h.Close())
+	assert.Nil(t, h.Close())
+
+	assert.Nil(t, h.Fire(logrus.NewEntry(logrus.New())))
+	assert.Equal(t, uint64(1), h.Dropped())
+}
+
+func TestNewSyslogHookInvalid(t *testing.T) {
+	for _, u := range []string{"http://localhost", "udp://", "::"} {
+		_, err := NewSyslogHook(SyslogConfig{URL: u})
+		assert.NotNil(t, err, u)
+	}
+
+	_, err := ParseSyslogFacility("nope")
+	assert.NotNil(t, err)
+
+	facility, err := ParseSyslogFacility("local3")
+	assert.Nil(t, err)
+	assert.Equal(t, 19, facility)
+}
+
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
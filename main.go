@@ -2,21 +2,35 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/aptible/supercronic/cron"
 	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/log/formatter"
 	"github.com/aptible/supercronic/log/hook"
+	"github.com/aptible/supercronic/metrics"
+	"github.com/aptible/supercronic/notify"
+	"github.com/aptible/supercronic/otlp"
 	"github.com/aptible/supercronic/prometheus_metrics"
-	"github.com/evalphobia/logrus_sentry"
+	"github.com/aptible/supercronic/redact"
+	"github.com/aptible/supercronic/tracing"
 	"github.com/fsnotify/fsnotify"
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
 )
 
 var (
@@ -31,7 +45,7 @@ var Usage = func() {
 func main() {
 	debug := flag.Bool("debug", false, "enable debug logging")
 	quiet := flag.Bool("quiet", false, "do not log informational messages (takes precedence over debug)")
-	json := flag.Bool("json", false, "enable JSON logging")
+	json := flag.Bool("json", false, "enable JSON logging (alias for -log-format json)")
 	printVersion := flag.Bool("version", false, "print version and exit")
 	test := flag.Bool("test", false, "test crontab (does not run jobs)")
 	inotify := flag.Bool("inotify", false, "use inotify to detect crontab file changes")
@@ -46,13 +60,100 @@ func main() {
 			prometheus_metrics.DefaultPort,
 		),
 	)
+	prometheusNamespace := flag.String("prometheus-namespace", prometheus_metrics.DefaultNamespace, "prefix of the names of Prometheus metrics")
+	var prometheusConstLabels stringListFlag
+	flag.Var(&prometheusConstLabels, "prometheus-const-label", "NAME=VALUE: label added to every Prometheus metric (can be repeated)")
+	prometheusBuckets := flag.String("prometheus-buckets", "", "comma-separated bucket boundaries in seconds of the execution time histogram (defaults to 10,30,60,120,300,600,1800,3600)")
+	prometheusNativeHistograms := flag.Bool("prometheus-native-histograms", false, "also expose the execution time histogram as a Prometheus native histogram")
+	metricsLabelMode := flag.String(
+		"metrics-label-mode",
+		string(metrics.LabelsFull),
+		"labels identifying jobs in Prometheus and OTLP metrics: full (command, position and schedule), "+
+			"command-hash (a hash of the command instead of the command), position-schedule, or name (job_name). "+
+			"full was the only mode of earlier versions; since the labels of every series change with the mode, "+
+			"update queries and alerts that use the command label before switching",
+	)
+	prometheusRuntimeMetrics := flag.Bool("prometheus-runtime-metrics", true, "expose Go runtime and process metrics along with job metrics")
 	splitLogs := flag.Bool("split-logs", false, "split log output into stdout/stderr")
 	passthroughLogs := flag.Bool("passthrough-logs", false, "passthrough logs from commands, do not wrap them in Supercronic logging")
-	sentry := flag.String("sentry-dsn", "", "enable Sentry error logging, using provided DSN")
+	sentryDsnFlag := flag.String("sentry-dsn", "", "enable Sentry error logging, using provided DSN")
 	sentryEnvironmentFlag := flag.String("sentry-environment", "", "specify the application's environment for Sentry error reporting")
 	sentryReleaseFlag := flag.String("sentry-release", "", "specify the application's release version for Sentry error reporting")
 	sentryAlias := flag.String("sentryDsn", "", "alias for sentry-dsn")
 	overlapping := flag.Bool("overlapping", false, "enable tasks overlapping")
+	hookBefore := flag.String("hook-before", "", "command to run before every job")
+	hookAfter := flag.String("hook-after", "", "command to run after every job, whether it succeeded or not")
+	hookOnFailure := flag.String("hook-on-failure", "", "command to run after every failed job")
+	hookOnSuccess := flag.String("hook-on-success", "", "command to run after every successful job")
+	outputTailLines := flag.Int("output-tail-lines", 20, "number of lines of job output kept for failure reports, hooks and notifications")
+	outputTailBytes := flag.Int("output-tail-bytes", 16*1024, "maximum size in bytes of the job output kept for failure reports (0 for no limit besides -output-tail-lines)")
+	var notifyWebhooks stringListFlag
+	flag.Var(&notifyWebhooks, "notify-webhook", "POST a JSON notification to this URL on job events (can be repeated)")
+	notifyWebhookTemplate := flag.String("notify-webhook-template", "json", "body of webhook notifications: json, slack, teams, or the path to a Go template")
+	notifyEvents := flag.String("notify-events", "failure,recovery,timeout,missed", "comma-separated list of events to send notifications for")
+	notifyTimeout := flag.Duration("notify-timeout", 30*time.Second, "maximum time spent sending a notification, including retries")
+	pingTimeout := flag.Duration("ping-timeout", 10*time.Second, "timeout of each attempt to send a ping")
+	pingRetries := flag.Int("ping-retries", 3, "number of times a failed ping is retried")
+	smtpAddress := flag.String("smtp-address", "", "enable email notifications, using the SMTP server at this host:port")
+	smtpUsername := flag.String("smtp-username", "", "username for SMTP authentication")
+	smtpPassword := flag.String("smtp-password", "", "password for SMTP authentication (can also be set with SMTP_PASSWORD)")
+	smtpStartTLS := flag.Bool("smtp-starttls", true, "use STARTTLS when the SMTP server supports it")
+	mailFrom := flag.String("mail-from", "", "sender of email notifications (defaults to supercronic@HOSTNAME)")
+	mailTo := flag.String("mail-to", "", "recipients of email notifications, for jobs without MAILTO")
+	mailOn := flag.String("mail-on", "output", "when to send job output by email: always, output (when there is output) or failure")
+	mailMaxSize := flag.Int("mail-max-size", 64*1024, "maximum size in bytes of the job output sent by email: longer output is truncated from the start")
+	mailAttach := flag.Bool("mail-attach", false, "attach job output to emails instead of including it in the body")
+	outputFormat := flag.String("output-format", "text", "how job output is parsed: text, or json to merge JSON object lines into log fields")
+	outputJSONPrefix := flag.String("output-json-prefix", "output.", "prefix of the log fields parsed from JSON job output")
+	stdoutLevel := flag.String("stdout-level", "info", "level at which the stdout of jobs is logged")
+	stderrLevel := flag.String("stderr-level", "info", "level at which the stderr of jobs is logged")
+	var outputLevelRules stringListFlag
+	flag.Var(&outputLevelRules, "output-level-rule", "LEVEL=REGEX: log lines of job output matching REGEX at LEVEL or above (can be repeated)")
+	longLines := flag.String("long-lines", "truncate", "how to log lines of job output longer than -max-line-length: truncate, or split into several entries")
+	maxLineLength := flag.Int("max-line-length", 1024*1024, "maximum length in bytes of a line of job output")
+	var redactEnv, redactPatterns, redactFiles stringListFlag
+	flag.Var(&redactEnv, "redact-env", "mask the value of this environment variable in logs and notifications (can be repeated)")
+	flag.Var(&redactPatterns, "redact-pattern", "mask matches of this regular expression (or its capture groups) in logs and notifications (can be repeated)")
+	flag.Var(&redactFiles, "redact-file", "mask the contents of this file in logs and notifications (can be repeated)")
+	outputRateLimit := flag.Float64("output-rate-limit", 0, "maximum number of lines of output logged per second for each job run (0 for no limit)")
+	outputBurst := flag.Int("output-burst", 0, "number of lines of output that can be logged at once above -output-rate-limit (defaults to the rate)")
+	outputByteLimit := flag.Int64("output-byte-limit", 0, "maximum number of bytes of output logged for each job run (0 for no limit)")
+	outputDir := flag.String("output-dir", "", "write the raw output of jobs to files under this directory, in a subdirectory per job")
+	outputFileMode := flag.String("output-file", "run", "how output files are written under -output-dir: run (a file per run), or rolling (a file per job)")
+	outputFileOnly := flag.Bool("output-file-only", false, "write job output to files only, instead of also logging it")
+	outputFileMaxSize := flag.Int64("output-file-max-size", 100*1024*1024, "size in bytes over which rolling output files are rotated (0 for no limit)")
+	outputFileMaxAge := flag.Duration("output-file-max-age", 0, "age after which rolling output files are rotated (0 for no limit)")
+	outputFileRetain := flag.Int("output-file-retain", 10, "number of finished or rotated output files kept per job (0 to keep all)")
+	outputFileCompress := flag.Bool("output-file-compress", false, "gzip finished or rotated output files")
+	syslogAddress := flag.String("syslog-address", "", "also send logs to syslog at this address: udp://HOST:PORT, tcp://HOST:PORT, tls://HOST:PORT or unixgram:///PATH")
+	syslogFacility := flag.String("syslog-facility", "cron", "syslog facility of the logs sent to syslog")
+	syslogAppName := flag.String("syslog-app-name", "supercronic", "app name of the logs sent to syslog")
+	syslogTLSCA := flag.String("syslog-tls-ca", "", "PEM file of the certificate authorities used to verify a tls:// syslog server (defaults to the system roots)")
+	logTarget := flag.String("log-target", "console", "where logs are written: console (stdout/stderr), or journald using its native protocol")
+	logFormat := flag.String("log-format", "text", "format of logs: text, json, ecs (Elastic Common Schema), gelf, logfmt, or template")
+	logJSONKeys := flag.String("log-json-keys", "", "comma-separated KEY=NAME pairs renaming the time, level and msg keys of text, json and logfmt logs")
+	logTimestampPrecision := flag.String("log-timestamp-precision", "", "precision of log timestamps: s, ms, us or ns")
+	logTemplate := flag.String("log-template", "", "Go template used to format logs with -log-format template, or @PATH to read it from a file")
+	gelfAddress := flag.String("gelf-address", "", "send GELF logs to this address (udp://HOST:PORT or tcp://HOST:PORT) instead of writing them to stdout")
+	var logRoutes stringListFlag
+	flag.Var(&logRoutes, "log-route", "SELECTOR=DESTINATION: send logs matching SELECTOR (a level, LEVEL+, channel:NAME or *) to DESTINATION (stdout, stderr, discard or file:PATH, comma-separated). Logs go to every route they match, and logs that match none follow the default routes (can be repeated)")
+	sentryRateLimit := flag.Int("sentry-rate-limit", 0, "maximum number of Sentry reports per job per hour (0 for no limit)")
+	sentryCronMonitors := flag.Bool("sentry-cron-monitors", false, "send Sentry Cron Monitor check-ins for every job run")
+	otlpTracesEndpoint := flag.String("otlp-traces-endpoint", "", "export a span for every job run to the OTLP collector at this URL (e.g. http://localhost:4318)")
+	otlpMetricsEndpoint := flag.String("otlp-metrics-endpoint", "", "export job metrics to the OTLP collector at this URL (e.g. http://localhost:4318)")
+	otlpMetricsInterval := flag.Duration("otlp-metrics-interval", time.Minute, "interval at which job metrics are exported to -otlp-metrics-endpoint")
+	pushgatewayURL := flag.String("pushgateway-url", "", "push metrics to the Prometheus Pushgateway at this URL after every job run and on exit")
+	pushgatewayJob := flag.String("pushgateway-job", "supercronic", "job label of the metrics pushed to the Pushgateway")
+	var pushgatewayGrouping stringListFlag
+	flag.Var(&pushgatewayGrouping, "pushgateway-grouping", "NAME=VALUE: additional grouping label of the metrics pushed to the Pushgateway (can be repeated)")
+	pushgatewayUsername := flag.String("pushgateway-username", "", "username for Pushgateway basic authentication")
+	pushgatewayPassword := flag.String("pushgateway-password", "", "password for Pushgateway basic authentication (can also be set with PUSHGATEWAY_PASSWORD)")
+	pushgatewayRetries := flag.Int("pushgateway-retries", 3, "number of times a failed push is retried")
+	pushgatewayDelete := flag.Bool("pushgateway-delete-on-exit", false, "delete the pushed metrics from the Pushgateway when exiting, instead of pushing them one last time")
+	statsdAddress := flag.String("statsd-address", "", "send job metrics to the StatsD server at this address: udp://HOST:PORT or unixgram:///PATH")
+	statsdFormat := flag.String("statsd-format", "dogstatsd", "format of StatsD metrics: dogstatsd (with tags), or statsd (with the job name in metric names)")
+	statsdPrefix := flag.String("statsd-prefix", "supercronic.", "prefix of the names of StatsD metrics")
+	otlpProtocol := flag.String("otlp-protocol", "http", "protocol used to export to OTLP collectors: http, or grpc")
 	flag.Parse()
 
 	var (
@@ -69,8 +170,8 @@ func main() {
 		sentryDsn = *sentryAlias
 	}
 
-	if *sentry != "" {
-		sentryDsn = *sentry
+	if *sentryDsnFlag != "" {
+		sentryDsn = *sentryDsnFlag
 	}
 
 	if *sentryEnvironmentFlag != "" {
@@ -90,16 +191,104 @@ func main() {
 	}
 
 	if *json {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
-	} else {
-		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+		*logFormat = "json"
+	}
+
+	formatterOpts := formatter.Options{Template: *logTemplate}
+
+	if *logTimestampPrecision != "" {
+		tsFormat, err := formatter.ParseTimestampPrecision(*logTimestampPrecision)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		formatterOpts.TimestampFormat = tsFormat
+	}
+
+	fieldMap, err := formatter.ParseFieldMap(splitList(*logJSONKeys))
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+	formatterOpts.FieldMap = fieldMap
+
+	logFormatter, err := formatter.New(*logFormat, formatterOpts)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+	logrus.SetFormatter(logFormatter)
+
+	var redactor *redact.Redactor
+	if len(redactEnv) > 0 || len(redactPatterns) > 0 || len(redactFiles) > 0 {
+		patterns := []*regexp.Regexp{}
+		for _, p := range redactPatterns {
+			pattern, err := regexp.Compile(p)
+			if err != nil {
+				logrus.Fatalf("invalid redaction pattern: %v", err)
+				return
+			}
+			patterns = append(patterns, pattern)
+		}
+
+		redactor = redact.New(patterns)
+
+		// This must be the first hook, so that other hooks only see
+		// redacted entries.
+		hook.RegisterRedactor(logrus.StandardLogger(), redactor)
+	}
+
+	if *gelfAddress != "" {
+		if *logFormat != "gelf" || *logTarget != "console" || *splitLogs || len(logRoutes) > 0 {
+			logrus.Fatal("-gelf-address requires -log-format gelf, and cannot be used with -split-logs, -log-route or -log-target")
+			return
+		}
+
+		gelfWriter, err := formatter.NewGELFWriter(*gelfAddress)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		defer gelfWriter.Close()
+
+		logrus.SetOutput(gelfWriter)
 	}
-	if *splitLogs {
-		hook.RegisterSplitLogger(
-			logrus.StandardLogger(),
-			os.Stdout,
-			os.Stderr,
-		)
+
+	switch *logTarget {
+	case "console":
+		if *splitLogs || len(logRoutes) > 0 {
+			routes, err := hook.ParseRoutes(logRoutes, os.Stdout, os.Stderr)
+			if err != nil {
+				logrus.Fatal(err)
+				return
+			}
+
+			if *splitLogs {
+				routes = append(routes, hook.SplitRoutes(os.Stdout, os.Stderr)...)
+			} else {
+				routes = append(routes, hook.Route{Levels: logrus.AllLevels, Writers: []io.Writer{os.Stderr}, Default: true})
+			}
+
+			hook.RegisterRoutedLogger(logrus.StandardLogger(), routes)
+		}
+	case "journald":
+		if len(logRoutes) > 0 {
+			logrus.Fatal("-log-route cannot be used with -log-target journald")
+			return
+		}
+
+		journalHook, err := hook.NewJournalHook(hook.JournalSocket, "supercronic")
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		defer journalHook.Close()
+
+		logrus.StandardLogger().AddHook(journalHook)
+		logrus.SetOutput(io.Discard)
+	default:
+		logrus.Fatalf("unknown log target: %q", *logTarget)
+		return
 	}
 
 	if *printVersion {
@@ -146,38 +335,221 @@ func main() {
 		}
 	}
 
-	var sentryHook *logrus_sentry.SentryHook
+	var sentryHook *hook.SentryHook
+	var sentryMonitor *notify.SentryMonitor
 	if sentryDsn != "" {
+		sentryClient, err := sentry.NewClient(sentry.ClientOptions{
+			Dsn:         sentryDsn,
+			Environment: sentryEnvironment,
+			Release:     sentryRelease,
+		})
+		if err != nil {
+			logrus.Fatalf("Could not init sentry logger: %s", err)
+			return
+		}
+
 		sentryLevels := []logrus.Level{
 			logrus.PanicLevel,
 			logrus.FatalLevel,
 			logrus.ErrorLevel,
 		}
-		sh, err := logrus_sentry.NewSentryHook(sentryDsn, sentryLevels)
+		sentryHook = hook.NewSentryHook(sentryClient, sentryLevels, *sentryRateLimit)
+		logrus.StandardLogger().AddHook(sentryHook)
+
+		// Reports are sent in the background: give them a chance to
+		// be sent before exiting on a fatal error.
+		logrus.RegisterExitHandler(func() {
+			sentryHook.Flush(5 * time.Second)
+		})
+
+		if *sentryCronMonitors {
+			sentryMonitor = notify.NewSentryMonitor(sentryClient)
+		}
+	}
+
+	if *syslogAddress != "" {
+		facility, err := hook.ParseSyslogFacility(*syslogFacility)
 		if err != nil {
-			logrus.Fatalf("Could not init sentry logger: %s", err)
-		} else {
-			sh.Timeout = 5 * time.Second
-			sentryHook = sh
+			logrus.Fatal(err)
+			return
 		}
 
-		if sentryEnvironment != "" {
-			sh.SetEnvironment(sentryEnvironment)
+		config := hook.SyslogConfig{
+			URL:      *syslogAddress,
+			Facility: facility,
+			AppName:  *syslogAppName,
 		}
 
-		if sentryRelease != "" {
-			sh.SetRelease(sentryRelease)
+		if *syslogTLSCA != "" {
+			pem, err := os.ReadFile(*syslogTLSCA)
+			if err != nil {
+				logrus.Fatalf("failed to read syslog certificate authorities: %v", err)
+				return
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				logrus.Fatalf("no certificates found in %s", *syslogTLSCA)
+				return
+			}
+			config.TLS = &tls.Config{RootCAs: pool}
 		}
 
-		if sentryHook != nil {
-			logrus.StandardLogger().AddHook(sentryHook)
+		syslogHook, err := hook.NewSyslogHook(config)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		defer syslogHook.Close()
+
+		logrus.StandardLogger().AddHook(syslogHook)
+	}
+
+	otlpProto, err := otlp.ParseProtocol(*otlpProtocol)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+
+	if *otlpTracesEndpoint != "" || *otlpMetricsEndpoint != "" {
+		// Telemetry is exported in the background: failures are
+		// reported here, and never affect jobs.
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			logrus.Warnf("failed to export telemetry: %v", err)
+		}))
+	}
+
+	var tracer *tracing.Tracer
+	if *otlpTracesEndpoint != "" {
+		tracer, err = tracing.NewTracer(tracing.Config{
+			Endpoint: *otlpTracesEndpoint,
+			Protocol: otlpProto,
+			Redactor: redactor,
+		})
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+	}
+
+	labelMode, err := metrics.ParseLabelMode(*metricsLabelMode)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+
+	promOpts := prometheus_metrics.Options{
+		Namespace:      *prometheusNamespace,
+		LabelMode:      labelMode,
+		ConstLabels:    prometheus.Labels{},
+		RuntimeMetrics: *prometheusRuntimeMetrics,
+	}
+
+	for _, l := range prometheusConstLabels {
+		parts := strings.SplitN(l, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			logrus.Fatalf("invalid prometheus label (expected NAME=VALUE): %q", l)
+			return
+		}
+		promOpts.ConstLabels[parts[0]] = parts[1]
+	}
+
+	if *prometheusBuckets != "" {
+		for _, b := range strings.Split(*prometheusBuckets, ",") {
+			bucket, err := strconv.ParseFloat(strings.TrimSpace(b), 64)
+			if err != nil {
+				logrus.Fatalf("invalid prometheus bucket: %q", b)
+				return
+			}
+			promOpts.Buckets = append(promOpts.Buckets, bucket)
+		}
+	}
+
+	if *prometheusNativeHistograms {
+		// The bucket factor recommended by the Prometheus client
+		promOpts.NativeHistogramBucketFactor = 1.1
+	}
+
+	registry := prometheus.NewRegistry()
+	promMetrics, err := prometheus_metrics.NewPrometheusMetrics(registry, promOpts)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+	recorders := []metrics.Recorder{promMetrics}
+
+	var pusher *prometheus_metrics.Pusher
+	if *pushgatewayURL != "" {
+		grouping := map[string]string{}
+		for _, g := range pushgatewayGrouping {
+			parts := strings.SplitN(g, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				logrus.Fatalf("invalid pushgateway grouping label (expected NAME=VALUE): %q", g)
+				return
+			}
+			grouping[parts[0]] = parts[1]
 		}
+
+		password := *pushgatewayPassword
+		if password == "" {
+			password = os.Getenv("PUSHGATEWAY_PASSWORD")
+		}
+
+		pusher = prometheus_metrics.NewPusher(prometheus_metrics.PushConfig{
+			URL:          *pushgatewayURL,
+			Job:          *pushgatewayJob,
+			Grouping:     grouping,
+			Username:     *pushgatewayUsername,
+			Password:     password,
+			Retries:      *pushgatewayRetries,
+			DeleteOnExit: *pushgatewayDelete,
+		}, registry, logrus.NewEntry(logrus.StandardLogger()))
+
+		// Pushes happen after the runs are recorded
+		recorders = append(recorders, pusher)
 	}
 
-	promMetrics := prometheus_metrics.NewPrometheusMetrics()
+	var statsd *metrics.StatsD
+	if *statsdAddress != "" {
+		format, err := metrics.ParseStatsDFormat(*statsdFormat)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		statsd, err = metrics.NewStatsD(metrics.StatsDConfig{
+			URL:    *statsdAddress,
+			Format: format,
+			Prefix: *statsdPrefix,
+		})
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		recorders = append(recorders, statsd)
+	}
+
+	var otlpRecorder *metrics.OTLPRecorder
+	if *otlpMetricsEndpoint != "" {
+		otlpRecorder, err = metrics.NewOTLPRecorder(metrics.OTLPConfig{
+			Endpoint:  *otlpMetricsEndpoint,
+			Protocol:  otlpProto,
+			Interval:  *otlpMetricsInterval,
+			LabelMode: labelMode,
+			Namespace: promOpts.Namespace,
+			Buckets:   promOpts.Buckets,
+		})
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		recorders = append(recorders, otlpRecorder)
+	}
 
 	if *prometheusListen != "" {
-		promServerShutdownClosure, err := prometheus_metrics.InitHTTPServer(*prometheusListen, context.Background())
+		promServerShutdownClosure, err := prometheus_metrics.InitHTTPServer(*prometheusListen, context.Background(), registry)
 		if err != nil {
 			logrus.Fatalf("prometheus http startup failed: %s", err.Error())
 		}
@@ -189,6 +561,154 @@ func main() {
 		}()
 	}
 
+	var notifier *notify.Dispatcher
+	if len(notifyWebhooks) > 0 || *smtpAddress != "" {
+		notifier = notify.NewDispatcher(logrus.NewEntry(logrus.StandardLogger()), *notifyTimeout)
+		notifier.Redactor = redactor
+	}
+
+	if len(notifyWebhooks) > 0 {
+		kinds, err := notify.ParseKinds(splitList(*notifyEvents))
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		tmpl, err := notify.LoadTemplate(*notifyWebhookTemplate)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		for _, url := range notifyWebhooks {
+			notifier.Subscribe(notify.NewWebhook(url, tmpl), kinds)
+		}
+	}
+
+	mailOutputBytes := 0
+	if *smtpAddress != "" {
+		on, err := notify.ParseMailOn(*mailOn)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+
+		if *mailMaxSize <= 0 {
+			logrus.Fatal("-mail-max-size must be positive")
+			return
+		}
+
+		// The output sent by email is kept apart from the short tail
+		// used in failure reports
+		mailOutputBytes = *mailMaxSize
+
+		from := *mailFrom
+		if from == "" {
+			host, _ := os.Hostname()
+			from = fmt.Sprintf("supercronic@%s", host)
+		}
+
+		password := *smtpPassword
+		if password == "" {
+			password = os.Getenv("SMTP_PASSWORD")
+		}
+
+		notifier.Subscribe(&notify.Email{
+			Addr:     *smtpAddress,
+			Username: *smtpUsername,
+			Password: password,
+			StartTLS: *smtpStartTLS,
+			From:     from,
+			To:       notify.ParseMailTo(*mailTo),
+			On:       on,
+			MaxSize:  *mailMaxSize,
+			Attach:   *mailAttach,
+		}, notify.MailKinds)
+	}
+
+	pinger := notify.NewPinger(logrus.NewEntry(logrus.StandardLogger()), *pingTimeout, *pingRetries)
+	pinger.Redactor = redactor
+
+	jobOutputFormat, err := cron.ParseOutputFormat(*outputFormat)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+
+	channelLevels := make(map[string]logrus.Level)
+	for channel, value := range map[string]string{"stdout": *stdoutLevel, "stderr": *stderrLevel} {
+		level, err := cron.ParseOutputLevel(value)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		channelLevels[channel] = level
+	}
+
+	levelRules := []cron.LevelRule{}
+	for _, value := range outputLevelRules {
+		rule, err := cron.ParseLevelRule(value)
+		if err != nil {
+			logrus.Fatal(err)
+			return
+		}
+		levelRules = append(levelRules, rule)
+	}
+
+	longLinePolicy, err := cron.ParseLongLinePolicy(*longLines)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+
+	fileMode, err := cron.ParseOutputFileMode(*outputFileMode)
+	if err != nil {
+		logrus.Fatal(err)
+		return
+	}
+
+	if *outputFileOnly && *outputDir == "" {
+		logrus.Fatal("-output-file-only requires -output-dir")
+		return
+	}
+
+	cronOpts := &cron.Options{
+		Overlapping:     *overlapping,
+		PassthroughLogs: *passthroughLogs,
+		Hooks: cron.Hooks{
+			Before:    *hookBefore,
+			After:     *hookAfter,
+			OnFailure: *hookOnFailure,
+			OnSuccess: *hookOnSuccess,
+		},
+		OutputTailLines:  *outputTailLines,
+		OutputTailBytes:  *outputTailBytes,
+		MailOutputBytes:  mailOutputBytes,
+		OutputFormat:     jobOutputFormat,
+		OutputJSONPrefix: *outputJSONPrefix,
+		ChannelLevels:    channelLevels,
+		LevelRules:       levelRules,
+		LongLines:        longLinePolicy,
+		MaxLineLength:    *maxLineLength,
+		OutputRateLimit:  *outputRateLimit,
+		OutputBurst:      *outputBurst,
+		OutputByteLimit:  *outputByteLimit,
+		Metrics:          metrics.Multi(recorders...),
+		Notifier:         notifier,
+		Pinger:           pinger,
+		Monitor:          sentryMonitor,
+		Tracer:           tracer,
+		OutputFiles: cron.OutputFiles{
+			Dir:      *outputDir,
+			Mode:     fileMode,
+			Only:     *outputFileOnly,
+			MaxSize:  *outputFileMaxSize,
+			MaxAge:   *outputFileMaxAge,
+			Retain:   *outputFileRetain,
+			Compress: *outputFileCompress,
+		},
+	}
+
 	termChan := make(chan os.Signal, 1)
 	signal.Notify(termChan, signalList...)
 
@@ -228,8 +748,6 @@ func main() {
 	}
 
 	for {
-		promMetrics.Reset()
-
 		logrus.Infof("read crontab: %s", crontabFileName)
 		tab, err := readCrontabAtPath(crontabFileName)
 
@@ -238,23 +756,48 @@ func main() {
 			break
 		}
 
+		if redactor != nil {
+			secrets, err := redact.FileSecrets(redactFiles)
+			if err != nil {
+				logrus.Fatal(err)
+				break
+			}
+
+			redactor.SetSecrets(append(secrets, redact.EnvSecrets(redactEnv, tab.Context.Environ)...))
+		}
+
 		if *test {
 			logrus.Info("crontab is valid")
 			os.Exit(0)
 			break
 		}
 
+		// Only drop the metrics of jobs that were removed or changed:
+		// resetting every counter would break rate() and increase()
+		promMetrics.Prune(tab.Jobs)
+
 		var wg sync.WaitGroup
 		exitCtx, notifyExit := context.WithCancel(context.Background())
 
+		if sentryHook != nil {
+			var optOut []string
+			for _, job := range tab.Jobs {
+				if job.Annotations["sentry"] == "off" {
+					optOut = append(optOut, job.Name)
+				}
+			}
+			sentryHook.SetOptOut(optOut)
+		}
+
 		for _, job := range tab.Jobs {
 			cronLogger := logrus.WithFields(logrus.Fields{
+				"job.name":     job.Name,
 				"job.schedule": job.Schedule,
 				"job.command":  job.Command,
 				"job.position": job.Position,
 			})
 
-			cron.StartJob(&wg, tab.Context, job, exitCtx, cronLogger, *overlapping, *passthroughLogs, &promMetrics)
+			cron.StartJob(&wg, tab.Context, job, exitCtx, cronLogger, cronOpts)
 		}
 
 		termSig := <-termChan
@@ -270,6 +813,20 @@ func main() {
 		wg.Wait()
 
 		if termSig != syscall.SIGUSR2 {
+			notifier.Wait()
+			cronOpts.Pinger.Wait()
+			cronOpts.Monitor.Wait(5 * time.Second)
+			if err := cronOpts.Tracer.Shutdown(5 * time.Second); err != nil {
+				logrus.Warnf("failed to export traces: %v", err)
+			}
+			pusher.Shutdown()
+			statsd.Close()
+			if err := otlpRecorder.Shutdown(5 * time.Second); err != nil {
+				logrus.Warnf("failed to export metrics: %v", err)
+			}
+			if sentryHook != nil {
+				sentryHook.Flush(5 * time.Second)
+			}
 			logrus.Info("exiting")
 			break
 		}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+// LabelMode selects the labels that identify a job in its metrics. The
+// command of a job may be long, change between deployments, or contain
+// secrets: modes other than LabelsFull leave it out.
+type LabelMode string
+
+const (
+	// LabelsName identifies jobs by their name only, in a job_name label
+	// (Prometheus reserves job for the scrape job). Jobs should have unique
+	// names, or their metrics are merged.
+	LabelsName LabelMode = "name"
+
+	// LabelsPositionSchedule identifies jobs by their position in the
+	// crontab and their schedule.
+	LabelsPositionSchedule LabelMode = "position-schedule"
+
+	// LabelsCommandHash identifies jobs by their position, their schedule
+	// and a hash of their command.
+	LabelsCommandHash LabelMode = "command-hash"
+
+	// LabelsFull identifies jobs by their command, position and schedule.
+	// This was the only mode of earlier versions, and is the default.
+	LabelsFull LabelMode = "full"
+)
+
+func ParseLabelMode(s string) (LabelMode, error) {
+	switch LabelMode(s) {
+	case LabelsName, LabelsPositionSchedule, LabelsCommandHash, LabelsFull:
+		return LabelMode(s), nil
+	case "":
+		return LabelsFull, nil
+	default:
+		return "", fmt.Errorf("unknown label mode: %q", s)
+	}
+}
+
+// Names returns the names of the labels of mode, in the order metrics are
+// labelled with.
+func (m LabelMode) Names() []string {
+	switch m {
+	case LabelsName:
+		return []string{"job_name"}
+	case LabelsPositionSchedule:
+		return []string{"position", "schedule"}
+	case LabelsCommandHash:
+		return []string{"command_hash", "position", "schedule"}
+	default:
+		return []string{"command", "position", "schedule"}
+	}
+}
+
+// Help completes the help text of a metric with the labels of m, and how to
+// switch modes: changing the mode changes the labels of every series, and
+// LabelsFull keeps the labels of earlier versions.
+func (m LabelMode) Help(help string) string {
+	labels := strings.Join(m.Names(), ", ")
+
+	if m == LabelsFull || m == "" {
+		return fmt.Sprintf(
+			"%s; jobs are identified by %s (label mode %q: other modes leave the command out, but change the labels of every series)",
+			help, labels, LabelsFull,
+		)
+	}
+
+	return fmt.Sprintf(
+		"%s; jobs are identified by %s (label mode %q: use label mode %q to keep the command, position and schedule labels of earlier versions)",
+		help, labels, m, LabelsFull,
+	)
+}
+
+// Labels returns the labels identifying job.
+func (m LabelMode) Labels(job *crontab.Job) map[string]string {
+	labels := map[string]string{}
+
+	for _, name := range m.Names() {
+		switch name {
+		case "job_name":
+			labels[name] = job.Name
+		case "position":
+			labels[name] = strconv.Itoa(job.Position)
+		case "schedule":
+			labels[name] = job.Schedule
+		case "command_hash":
+			labels[name] = CommandHash(job.Command)
+		case "command":
+			labels[name] = job.Command
+		}
+	}
+
+	return labels
+}
+
+// CommandHash returns the hash of command used by LabelsCommandHash: the
+// first 12 hex digits of its SHA-256.
+func CommandHash(command string) string {
+	sum := sha256.Sum256([]byte(command))
+	return hex.EncodeToString(sum[:])[:12]
+}
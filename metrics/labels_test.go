@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLabelMode(t *testing.T) {
+	for _, s := range []string{"name", "position-schedule", "command-hash", "full"} {
+		mode, err := ParseLabelMode(s)
+		if assert.NoError(t, err) {
+			assert.Equal(t, LabelMode(s), mode)
+		}
+	}
+
+	mode, err := ParseLabelMode("")
+	if assert.NoError(t, err) {
+		assert.Equal(t, LabelsFull, mode)
+	}
+
+	_, err = ParseLabelMode("command")
+	assert.Error(t, err)
+}
+
+func TestLabelsCommandHash(t *testing.T) {
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "@daily", Command: "backup.sh --token secret"},
+		Position:    2,
+	}
+
+	labels := LabelsCommandHash.Labels(job)
+	assert.Equal(t, map[string]string{
+		"command_hash": "dd9ff11cb89b",
+		"position":     "2",
+		"schedule":     "@daily",
+	}, labels)
+	assert.Len(t, labels, len(LabelsCommandHash.Names()))
+
+	// The hash only depends on the command
+	other := *job
+	other.Position = 5
+	assert.Equal(t, labels["command_hash"], LabelsCommandHash.Labels(&other)["command_hash"])
+
+	other.Command = "backup.sh --token other"
+	assert.NotEqual(t, labels["command_hash"], LabelsCommandHash.Labels(&other)["command_hash"])
+}
+
+func TestLabelModeHelp(t *testing.T) {
+	assert.Equal(
+		t,
+		`count of cron executions; jobs are identified by command, position, schedule (label mode "full": other modes leave the command out, but change the labels of every series)`,
+		LabelsFull.Help("count of cron executions"),
+	)
+
+	assert.Equal(
+		t,
+		`count of cron executions; jobs are identified by job_name (label mode "name": use label mode "full" to keep the command, position and schedule labels of earlier versions)`,
+		LabelsName.Help("count of cron executions"),
+	)
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+// DefaultNamespace prefixes the names of metrics, in Prometheus and OTLP.
+const DefaultNamespace = "supercronic"
+
+// DefaultBuckets are the buckets of the execution time histogram, in
+// seconds.
+var DefaultBuckets = []float64{10.0, 30.0, 60.0, 120.0, 300.0, 600.0, 1800.0, 3600.0}
+
+// Recorder records the metrics of jobs. Implementations must be safe for
+// concurrent use, and must never block the job being recorded.
+type Recorder interface {
+	// RunStarted and RunEnded bracket a run of the job, including its
+	// hooks.
+	RunStarted(job *crontab.Job)
+	RunEnded(job *crontab.Job)
+
+	// RunFinished records the duration and exit code of a run, once its
+	// command has exited. Runs succeed when their exit code is 0, and
+	// commands that could not be run have an exit code of -1.
+	RunFinished(job *crontab.Job, duration time.Duration, exitCode int)
+
+	// RunScheduled records when the job is scheduled to run next.
+	RunScheduled(job *crontab.Job, next time.Time)
+
+	// DeadlineExceeded records that the job was still running when it
+	// should have started again.
+	DeadlineExceeded(job *crontab.Job)
+
+	HookFailed(job *crontab.Job, hook string)
+	OutputDropped(job *crontab.Job, channel string, lines uint64)
+}
+
+type multiRecorder []Recorder
+
+// Multi returns a Recorder that records into all the given recorders. Nil
+// recorders are skipped.
+func Multi(recorders ...Recorder) Recorder {
+	m := multiRecorder{}
+	for _, r := range recorders {
+		if r != nil {
+			m = append(m, r)
+		}
+	}
+
+	if len(m) == 1 {
+		return m[0]
+	}
+
+	return m
+}
+
+func (m multiRecorder) RunStarted(job *crontab.Job) {
+	for _, r := range m {
+		r.RunStarted(job)
+	}
+}
+
+func (m multiRecorder) RunEnded(job *crontab.Job) {
+	for _, r := range m {
+		r.RunEnded(job)
+	}
+}
+
+func (m multiRecorder) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {
+	for _, r := range m {
+		r.RunFinished(job, duration, exitCode)
+	}
+}
+
+func (m multiRecorder) RunScheduled(job *crontab.Job, next time.Time) {
+	for _, r := range m {
+		r.RunScheduled(job, next)
+	}
+}
+
+func (m multiRecorder) DeadlineExceeded(job *crontab.Job) {
+	for _, r := range m {
+		r.DeadlineExceeded(job)
+	}
+}
+
+func (m multiRecorder) HookFailed(job *crontab.Job, hook string) {
+	for _, r := range m {
+		r.HookFailed(job, hook)
+	}
+}
+
+func (m multiRecorder) OutputDropped(job *crontab.Job, channel string, lines uint64) {
+	for _, r := range m {
+		r.OutputDropped(job, channel, lines)
+	}
+}
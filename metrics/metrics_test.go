@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingRecorder struct {
+	calls []string
+}
+
+func (r *countingRecorder) RunStarted(job *crontab.Job) {
+	r.calls = append(r.calls, "started")
+}
+
+func (r *countingRecorder) RunEnded(job *crontab.Job) {
+	r.calls = append(r.calls, "ended")
+}
+
+func (r *countingRecorder) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {
+	r.calls = append(r.calls, "finished")
+}
+
+func (r *countingRecorder) RunScheduled(job *crontab.Job, next time.Time) {
+	r.calls = append(r.calls, "scheduled")
+}
+
+func (r *countingRecorder) DeadlineExceeded(job *crontab.Job) {
+	r.calls = append(r.calls, "deadline")
+}
+
+func (r *countingRecorder) HookFailed(job *crontab.Job, hook string) {
+	r.calls = append(r.calls, "hook "+hook)
+}
+
+func (r *countingRecorder) OutputDropped(job *crontab.Job, channel string, lines uint64) {
+	r.calls = append(r.calls, "dropped "+channel)
+}
+
+func TestMulti(t *testing.T) {
+	a := &countingRecorder{}
+	b := &countingRecorder{}
+
+	r := Multi(a, nil, b)
+	job := &crontab.Job{}
+
+	r.RunStarted(job)
+	r.RunFinished(job, time.Second, 0)
+	r.HookFailed(job, "after")
+	r.OutputDropped(job, "stdout", 1)
+	r.DeadlineExceeded(job)
+	r.RunEnded(job)
+	r.RunScheduled(job, time.Now())
+
+	expected := []string{"started", "finished", "hook after", "dropped stdout", "deadline", "ended", "scheduled"}
+	assert.Equal(t, expected, a.calls)
+	assert.Equal(t, expected, b.calls)
+
+	assert.Equal(t, a, Multi(nil, a))
+
+	// No recorders discards everything
+	Multi().RunStarted(job)
+}
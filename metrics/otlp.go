@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/otlp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// OTLPConfig configures an OTLPRecorder. Endpoint is the URL of the OTLP
+// collector, and metrics are exported to it every Interval. As with traces,
+// other settings can be set with the standard OTEL_EXPORTER_OTLP_*
+// variables. LabelMode selects the attributes identifying jobs. Namespace
+// prefixes the names of the metrics (DefaultNamespace by default), and
+// Buckets are the buckets of the execution time histogram (DefaultBuckets by
+// default), like for Prometheus.
+type OTLPConfig struct {
+	Endpoint  string
+	Protocol  otlp.Protocol
+	Interval  time.Duration
+	Timeout   time.Duration
+	LabelMode LabelMode
+	Namespace string
+	Buckets   []float64
+}
+
+// OTLPRecorder exports job metrics to an OTLP collector. It has the same
+// instruments as the Prometheus metrics, with the same names, attributes and
+// buckets. Metrics are exported in the background: a slow or unreachable
+// collector never delays jobs.
+type OTLPRecorder struct {
+	provider  *sdkmetric.MeterProvider
+	labelMode LabelMode
+
+	running          metric.Int64UpDownCounter
+	executions       metric.Int64Counter
+	successes        metric.Int64Counter
+	failures         metric.Int64Counter
+	deadlineExceeded metric.Int64Counter
+	duration         metric.Float64Histogram
+	hookFailures     metric.Int64Counter
+	outputDropped    metric.Int64Counter
+}
+
+// NewOTLPRecorder creates an OTLPRecorder exporting to the collector of
+// config. Export errors are reported to the OpenTelemetry error handler (see
+// otel.SetErrorHandler).
+func NewOTLPRecorder(config OTLPConfig) (*OTLPRecorder, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %q", config.Endpoint)
+	}
+
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	if config.Namespace == "" {
+		config.Namespace = DefaultNamespace
+	}
+
+	if config.Buckets == nil {
+		config.Buckets = DefaultBuckets
+	}
+
+	name := func(name string) string {
+		return config.Namespace + "_" + name
+	}
+
+	// Creating the exporter does not connect to the collector: this never
+	// fails because the collector is down.
+	var exporter sdkmetric.Exporter
+	switch config.Protocol {
+	case otlp.ProtocolHTTP, "":
+		exporter, err = otlpmetrichttp.New(
+			context.Background(),
+			otlpmetrichttp.WithEndpointURL(config.Endpoint),
+			otlpmetrichttp.WithTimeout(config.Timeout),
+		)
+	case otlp.ProtocolGRPC:
+		exporter, err = otlpmetricgrpc.New(
+			context.Background(),
+			otlpmetricgrpc.WithEndpointURL(config.Endpoint),
+			otlpmetricgrpc.WithTimeout(config.Timeout),
+		)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol: %q", config.Protocol)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(
+			exporter,
+			sdkmetric.WithInterval(config.Interval),
+			sdkmetric.WithTimeout(config.Timeout),
+		)),
+		sdkmetric.WithResource(otlp.Resource()),
+	)
+
+	meter := provider.Meter(otlp.InstrumentationName)
+	r := &OTLPRecorder{provider: provider, labelMode: config.LabelMode}
+
+	// Instruments only fail to be created if their name is invalid
+	r.running, _ = meter.Int64UpDownCounter(
+		name("currently_running"),
+		metric.WithDescription(r.labelMode.Help("count of currently running cron executions")),
+	)
+	r.executions, _ = meter.Int64Counter(
+		name("executions"),
+		metric.WithDescription(r.labelMode.Help("count of cron executions")),
+	)
+	r.successes, _ = meter.Int64Counter(
+		name("successful_executions"),
+		metric.WithDescription(r.labelMode.Help("count of successul cron executions")),
+	)
+	r.failures, _ = meter.Int64Counter(
+		name("failed_executions"),
+		metric.WithDescription(r.labelMode.Help("count of failed cron executions")),
+	)
+	r.deadlineExceeded, _ = meter.Int64Counter(
+		name("deadline_exceeded"),
+		metric.WithDescription(r.labelMode.Help("count of exceeded deadline cron executions")),
+	)
+	r.duration, _ = meter.Float64Histogram(
+		name("cron_execution_time_seconds"),
+		metric.WithDescription(r.labelMode.Help("duration of the cron executions")),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(config.Buckets...),
+	)
+	r.hookFailures, _ = meter.Int64Counter(
+		name("hook_failures"),
+		metric.WithDescription(r.labelMode.Help("count of failed hook executions")),
+	)
+	r.outputDropped, _ = meter.Int64Counter(
+		name("output_dropped_lines"),
+		metric.WithDescription(r.labelMode.Help("count of lines of cron output that were not logged because of output limits")),
+	)
+
+	return r, nil
+}
+
+func (r *OTLPRecorder) jobAttributes(job *crontab.Job, extra ...attribute.KeyValue) metric.MeasurementOption {
+	labels := r.labelMode.Labels(job)
+
+	attrs := []attribute.KeyValue{}
+	for _, name := range r.labelMode.Names() {
+		attrs = append(attrs, attribute.String(name, labels[name]))
+	}
+
+	return metric.WithAttributes(append(attrs, extra...)...)
+}
+
+func (r *OTLPRecorder) RunStarted(job *crontab.Job) {
+	r.running.Add(context.Background(), 1, r.jobAttributes(job))
+}
+
+func (r *OTLPRecorder) RunEnded(job *crontab.Job) {
+	r.running.Add(context.Background(), -1, r.jobAttributes(job))
+}
+
+func (r *OTLPRecorder) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {
+	ctx := context.Background()
+	attrs := r.jobAttributes(job)
+
+	r.duration.Record(ctx, duration.Seconds(), attrs)
+	r.executions.Add(ctx, 1, attrs)
+
+	if exitCode == 0 {
+		r.successes.Add(ctx, 1, attrs)
+	} else {
+		r.failures.Add(ctx, 1, attrs)
+	}
+}
+
+func (r *OTLPRecorder) RunScheduled(job *crontab.Job, next time.Time) {}
+
+func (r *OTLPRecorder) DeadlineExceeded(job *crontab.Job) {
+	r.deadlineExceeded.Add(context.Background(), 1, r.jobAttributes(job))
+}
+
+func (r *OTLPRecorder) HookFailed(job *crontab.Job, hook string) {
+	r.hookFailures.Add(context.Background(), 1, r.jobAttributes(job, attribute.String("hook", hook)))
+}
+
+func (r *OTLPRecorder) OutputDropped(job *crontab.Job, channel string, lines uint64) {
+	r.outputDropped.Add(context.Background(), int64(lines), r.jobAttributes(job, attribute.String("channel", channel)))
+}
+
+// Shutdown exports the metrics recorded since the last export, giving up
+// after timeout.
+func (r *OTLPRecorder) Shutdown(timeout time.Duration) error {
+	if r == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return r.provider.Shutdown(ctx)
+}
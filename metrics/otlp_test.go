@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newCollector starts a stand-in for an OTLP collector, which records the
+// metrics it receives.
+func newCollector() (*httptest.Server, func() map[string]*metricspb.Metric) {
+	var mu sync.Mutex
+	metrics := map[string]*metricspb.Metric{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		req := &collectormetrics.ExportMetricsServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rm := range req.ResourceMetrics {
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					metrics[m.Name] = m
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&collectormetrics.ExportMetricsServiceResponse{})
+		w.Write(out)
+	}))
+
+	return srv, func() map[string]*metricspb.Metric {
+		mu.Lock()
+		defer mu.Unlock()
+		return metrics
+	}
+}
+
+func dataPointAttributes(attrs []*commonpb.KeyValue) map[string]string {
+	out := map[string]string{}
+	for _, kv := range attrs {
+		out[kv.Key] = kv.Value.GetStringValue()
+	}
+	return out
+}
+
+func TestOTLPRecorder(t *testing.T) {
+	srv, exported := newCollector()
+	defer srv.Close()
+
+	r, err := NewOTLPRecorder(OTLPConfig{Endpoint: srv.URL, Interval: time.Hour})
+	require.NoError(t, err)
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "* * * * *", Command: "backup.sh"},
+		Position:    4,
+	}
+
+	r.RunStarted(job)
+	r.RunFinished(job, 3*time.Second, 1)
+	r.HookFailed(job, "after")
+	r.OutputDropped(job, "stderr", 12)
+	r.RunEnded(job)
+
+	r.RunStarted(job)
+	r.RunFinished(job, 20*time.Second, 0)
+	r.DeadlineExceeded(job)
+
+	require.NoError(t, r.Shutdown(5*time.Second))
+
+	metrics := exported()
+
+	sum := func(name string) (int64, map[string]string) {
+		m, ok := metrics[name]
+		require.True(t, ok, name)
+		points := m.GetSum().DataPoints
+		require.Len(t, points, 1, name)
+		return points[0].GetAsInt(), dataPointAttributes(points[0].Attributes)
+	}
+
+	labels := map[string]string{"command": "backup.sh", "position": "4", "schedule": "* * * * *"}
+
+	v, attrs := sum("supercronic_executions")
+	assert.Equal(t, int64(2), v)
+	assert.Equal(t, labels, attrs)
+
+	v, _ = sum("supercronic_currently_running")
+	assert.Equal(t, int64(1), v)
+	assert.False(t, metrics["supercronic_currently_running"].GetSum().IsMonotonic)
+
+	v, _ = sum("supercronic_successful_executions")
+	assert.Equal(t, int64(1), v)
+
+	v, _ = sum("supercronic_failed_executions")
+	assert.Equal(t, int64(1), v)
+
+	v, _ = sum("supercronic_deadline_exceeded")
+	assert.Equal(t, int64(1), v)
+
+	v, attrs = sum("supercronic_hook_failures")
+	assert.Equal(t, int64(1), v)
+	assert.Equal(t, "after", attrs["hook"])
+
+	v, attrs = sum("supercronic_output_dropped_lines")
+	assert.Equal(t, int64(12), v)
+	assert.Equal(t, "stderr", attrs["channel"])
+
+	histogram := metrics["supercronic_cron_execution_time_seconds"].GetHistogram()
+	require.Len(t, histogram.DataPoints, 1)
+	assert.Equal(t, uint64(2), histogram.DataPoints[0].Count)
+	assert.Equal(t, 23.0, histogram.DataPoints[0].GetSum())
+	assert.Equal(t, []float64{10, 30, 60, 120, 300, 600, 1800, 3600}, histogram.DataPoints[0].ExplicitBounds)
+}
+
+func TestOTLPRecorderLabelMode(t *testing.T) {
+	srv, exported := newCollector()
+	defer srv.Close()
+
+	r, err := NewOTLPRecorder(OTLPConfig{Endpoint: srv.URL, Interval: time.Hour, LabelMode: LabelsName})
+	require.NoError(t, err)
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "* * * * *", Command: "backup.sh"},
+		Name:        "backup",
+	}
+
+	r.RunFinished(job, time.Second, 0)
+	r.HookFailed(job, "after")
+	require.NoError(t, r.Shutdown(5*time.Second))
+
+	metrics := exported()
+
+	points := metrics["supercronic_executions"].GetSum().DataPoints
+	require.Len(t, points, 1)
+	assert.Equal(t, map[string]string{"job_name": "backup"}, dataPointAttributes(points[0].Attributes))
+
+	points = metrics["supercronic_hook_failures"].GetSum().DataPoints
+	require.Len(t, points, 1)
+	assert.Equal(t, map[string]string{"job_name": "backup", "hook": "after"}, dataPointAttributes(points[0].Attributes))
+}
+
+func TestOTLPRecorderNamespaceAndBuckets(t *testing.T) {
+	srv, exported := newCollector()
+	defer srv.Close()
+
+	r, err := NewOTLPRecorder(OTLPConfig{
+		Endpoint:  srv.URL,
+		Interval:  time.Hour,
+		Namespace: "cron",
+		Buckets:   []float64{1, 5},
+	})
+	require.NoError(t, err)
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "* * * * *", Command: "backup.sh"},
+	}
+
+	r.RunFinished(job, 3*time.Second, 0)
+	require.NoError(t, r.Shutdown(5*time.Second))
+
+	metrics := exported()
+
+	assert.Contains(t, metrics, "cron_executions")
+	assert.NotContains(t, metrics, "supercronic_executions")
+
+	histogram := metrics["cron_cron_execution_time_seconds"].GetHistogram()
+	require.Len(t, histogram.DataPoints, 1)
+	assert.Equal(t, []float64{1, 5}, histogram.DataPoints[0].ExplicitBounds)
+}
+
+func TestOTLPRecorderUnreachableCollector(t *testing.T) {
+	srv, _ := newCollector()
+	srv.Close()
+
+	r, err := NewOTLPRecorder(OTLPConfig{Endpoint: srv.URL, Interval: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	job := &crontab.Job{}
+
+	t0 := time.Now()
+	for i := 0; i < 100; i++ {
+		r.RunStarted(job)
+		r.RunFinished(job, time.Second, 0)
+		r.RunEnded(job)
+	}
+	assert.Less(t, time.Since(t0), time.Second)
+
+	t0 = time.Now()
+	r.Shutdown(500 * time.Millisecond)
+	assert.Less(t, time.Since(t0), 2*time.Second)
+}
+
+func TestNewOTLPRecorderInvalidConfig(t *testing.T) {
+	_, err := NewOTLPRecorder(OTLPConfig{Endpoint: "localhost"})
+	assert.Error(t, err)
+
+	_, err = NewOTLPRecorder(OTLPConfig{Endpoint: "http://localhost:4318", Protocol: "udp"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,347 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+)
+
+type StatsDFormat string
+
+const (
+	// StatsDPlain sends metrics without tags: the job name is part of the
+	// metric name instead.
+	StatsDPlain StatsDFormat = "statsd"
+
+	// StatsDDogStatsD sends metrics with DogStatsD tags.
+	StatsDDogStatsD StatsDFormat = "dogstatsd"
+)
+
+func ParseStatsDFormat(s string) (StatsDFormat, error) {
+	switch StatsDFormat(s) {
+	case StatsDPlain, StatsDDogStatsD:
+		return StatsDFormat(s), nil
+	default:
+		return "", fmt.Errorf("unknown statsd format: %q", s)
+	}
+}
+
+// StatsDConfig configures a StatsD recorder. URL is the address of the
+// StatsD server or DogStatsD agent: udp://HOST:PORT or unixgram:///PATH.
+// Metrics are batched into packets of at most MaxPacketSize bytes, which are
+// sent every FlushInterval or as soon as they are full.
+type StatsDConfig struct {
+	URL           string
+	Format        StatsDFormat
+	Prefix        string
+	MaxPacketSize int
+	FlushInterval time.Duration
+}
+
+// StatsD sends job metrics to a StatsD server: counters of executions,
+// successes, failures, deadlines exceeded, hook failures and dropped output
+// lines, the execution time as a timing, and the number of running
+// instances as a gauge. Packets are sent in the background, and dropped if
+// they cannot be sent right away, so that a slow or missing server never
+// blocks jobs.
+type StatsD struct {
+	network string
+	address string
+	format  StatsDFormat
+	prefix  string
+
+	maxPacketSize int
+
+	mu      sync.Mutex
+	buf     []byte
+	running map[string]int64
+	closed  bool
+
+	packets chan []byte
+	conn    net.Conn
+	dropped uint64
+
+	ticker  *time.Ticker
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func NewStatsD(config StatsDConfig) (*StatsD, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statsd address: %w", err)
+	}
+
+	s := &StatsD{
+		format:        config.Format,
+		prefix:        config.Prefix,
+		maxPacketSize: config.MaxPacketSize,
+		running:       map[string]int64{},
+		packets:       make(chan []byte, 64),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	switch u.Scheme {
+	case "udp":
+		s.network = "udp"
+		s.address = u.Host
+		if s.maxPacketSize == 0 {
+			// Fits in the MTU of most networks
+			s.maxPacketSize = 1432
+		}
+	case "unixgram", "unix":
+		s.network = "unixgram"
+		s.address = u.Path
+		if s.maxPacketSize == 0 {
+			s.maxPacketSize = 8192
+		}
+	default:
+		return nil, fmt.Errorf("invalid statsd address: unsupported scheme %q", u.Scheme)
+	}
+
+	if s.address == "" {
+		return nil, fmt.Errorf("invalid statsd address: %q", config.URL)
+	}
+
+	switch s.format {
+	case "":
+		s.format = StatsDDogStatsD
+	case StatsDPlain, StatsDDogStatsD:
+	default:
+		return nil, fmt.Errorf("unknown statsd format: %q", s.format)
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Second
+	}
+
+	s.ticker = time.NewTicker(config.FlushInterval)
+
+	go s.run()
+
+	return s, nil
+}
+
+// run sends packets until the recorder is closed. Packets that cannot be
+// sent are dropped: StatsD is lossy by design.
+func (s *StatsD) run() {
+	defer close(s.stopped)
+
+	done := s.done
+
+	for {
+		select {
+		case packet, ok := <-s.packets:
+			if !ok {
+				return
+			}
+			s.send(packet)
+		case <-s.ticker.C:
+			s.flush()
+		case <-done:
+			// Send what is left, then stop once the queue is
+			// drained
+			s.mu.Lock()
+			s.queue()
+			s.closed = true
+			close(s.packets)
+			s.mu.Unlock()
+
+			done = nil
+		}
+	}
+}
+
+func (s *StatsD) send(packet []byte) {
+	if s.conn == nil {
+		// Dial lazily, so that an agent that is not up yet does
+		// not prevent supercronic from starting.
+		conn, err := net.DialTimeout(s.network, s.address, time.Second)
+		if err != nil {
+			atomic.AddUint64(&s.dropped, 1)
+			return
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+
+	if _, err := s.conn.Write(packet); err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Dropped returns the number of packets that could not be sent.
+func (s *StatsD) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// flush queues the metrics that are waiting to be sent.
+func (s *StatsD) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queue()
+}
+
+// queue queues the current packet. It must be called with s.mu held.
+func (s *StatsD) queue() {
+	if len(s.buf) == 0 {
+		return
+	}
+
+	if s.closed {
+		s.buf = nil
+		return
+	}
+
+	// The last metric is followed by a newline: strip it
+	packet := s.buf[:len(s.buf)-1]
+	s.buf = nil
+
+	select {
+	case s.packets <- packet:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+func (s *StatsD) emit(name string, value string, kind string, tags []string) {
+	var line strings.Builder
+
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+	line.WriteString(":")
+	line.WriteString(value)
+	line.WriteString("|")
+	line.WriteString(kind)
+
+	if s.format == StatsDDogStatsD && len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(tags, ","))
+	}
+
+	line.WriteString("\n")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf)+line.Len() > s.maxPacketSize+1 {
+		s.queue()
+	}
+
+	s.buf = append(s.buf, line.String()...)
+}
+
+var unsafeStatsDChars = regexp.MustCompile(`[^A-Za-z0-9_.\-/]+`)
+
+// metric returns the name of a metric of job: with the plain format, the
+// name of the job is part of it.
+func (s *StatsD) metric(job *crontab.Job, name string) string {
+	if s.format == StatsDPlain {
+		return "job." + unsafeStatsDChars.ReplaceAllString(job.Name, "_") + "." + name
+	}
+
+	return name
+}
+
+var unsafeStatsDTagChars = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
+
+func statsDTags(job *crontab.Job, extra ...string) []string {
+	tags := []string{
+		"job:" + unsafeStatsDTagChars.Replace(job.Name),
+		"position:" + strconv.Itoa(job.Position),
+		"schedule:" + unsafeStatsDTagChars.Replace(job.Schedule),
+	}
+
+	for _, tag := range extra {
+		tags = append(tags, unsafeStatsDTagChars.Replace(tag))
+	}
+
+	return tags
+}
+
+func (s *StatsD) count(job *crontab.Job, name string, n uint64, extra ...string) {
+	s.emit(s.metric(job, name), strconv.FormatUint(n, 10), "c", statsDTags(job, extra...))
+}
+
+func (s *StatsD) updateRunning(job *crontab.Job, delta int64) {
+	key := fmt.Sprintf("%d %s", job.Position, job.Name)
+
+	s.mu.Lock()
+	s.running[key] += delta
+	running := s.running[key]
+	if running == 0 {
+		// Forget jobs once they're done, so that the jobs removed from the
+		// crontab aren't kept forever
+		delete(s.running, key)
+	}
+	s.mu.Unlock()
+
+	s.emit(s.metric(job, "currently_running"), strconv.FormatInt(running, 10), "g", statsDTags(job))
+}
+
+func (s *StatsD) RunStarted(job *crontab.Job) {
+	s.updateRunning(job, 1)
+}
+
+func (s *StatsD) RunEnded(job *crontab.Job) {
+	s.updateRunning(job, -1)
+}
+
+func (s *StatsD) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {
+	ms := strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64)
+	s.emit(s.metric(job, "execution_time"), ms, "ms", statsDTags(job))
+
+	s.count(job, "executions", 1)
+
+	if exitCode == 0 {
+		s.count(job, "successful_executions", 1)
+	} else {
+		s.count(job, "failed_executions", 1)
+	}
+}
+
+func (s *StatsD) RunScheduled(job *crontab.Job, next time.Time) {}
+
+func (s *StatsD) DeadlineExceeded(job *crontab.Job) {
+	s.count(job, "deadline_exceeded", 1)
+}
+
+func (s *StatsD) HookFailed(job *crontab.Job, hook string) {
+	s.count(job, "hook_failures", 1, "hook:"+hook)
+}
+
+func (s *StatsD) OutputDropped(job *crontab.Job, channel string, lines uint64) {
+	s.count(job, "output_dropped_lines", lines, "channel:"+channel)
+}
+
+// Close sends the metrics that are waiting to be sent, and closes the
+// connection to the server.
+func (s *StatsD) Close() {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		s.ticker.Stop()
+		close(s.done)
+		<-s.stopped
+
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	})
+}
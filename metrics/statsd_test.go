@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var statsDJob = &crontab.Job{
+	CrontabLine: crontab.CrontabLine{Schedule: "*/5 * * * *", Command: "backup.sh"},
+	Position:    1,
+	Name:        "nightly backup",
+}
+
+// readPackets reads the packets sent to conn until none arrives for a while.
+func readPackets(t *testing.T, conn net.PacketConn) []string {
+	packets := []string{}
+	buf := make([]byte, 65536)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func lines(packets []string) []string {
+	out := []string{}
+	for _, p := range packets {
+		out = append(out, strings.Split(p, "\n")...)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestStatsDDogStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewStatsD(StatsDConfig{URL: "udp://" + conn.LocalAddr().String(), Prefix: "supercronic."})
+	require.NoError(t, err)
+
+	s.RunStarted(statsDJob)
+	s.RunFinished(statsDJob, 1500*time.Millisecond, 1)
+	s.HookFailed(statsDJob, "after")
+	s.OutputDropped(statsDJob, "stderr", 7)
+	s.DeadlineExceeded(statsDJob)
+	s.RunEnded(statsDJob)
+	s.Close()
+
+	packets := readPackets(t, conn)
+
+	// Everything fits in a single packet
+	assert.Len(t, packets, 1)
+
+	tags := "|#job:nightly backup,position:1,schedule:*/5 * * * *"
+	assert.Equal(t, []string{
+		"supercronic.currently_running:0|g" + tags,
+		"supercronic.currently_running:1|g" + tags,
+		"supercronic.deadline_exceeded:1|c" + tags,
+		"supercronic.execution_time:1500.000|ms" + tags,
+		"supercronic.executions:1|c" + tags,
+		"supercronic.failed_executions:1|c" + tags,
+		"supercronic.hook_failures:1|c" + tags + ",hook:after",
+		"supercronic.output_dropped_lines:7|c" + tags + ",channel:stderr",
+	}, lines(packets))
+}
+
+func TestStatsDForgetsFinishedJobs(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewStatsD(StatsDConfig{URL: "udp://" + conn.LocalAddr().String()})
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.RunStarted(statsDJob)
+	s.RunStarted(statsDJob)
+	s.RunEnded(statsDJob)
+
+	s.mu.Lock()
+	assert.Len(t, s.running, 1)
+	s.mu.Unlock()
+
+	s.RunEnded(statsDJob)
+
+	s.mu.Lock()
+	assert.Empty(t, s.running)
+	s.mu.Unlock()
+}
+
+func TestStatsDPlain(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewStatsD(StatsDConfig{URL: "udp://" + conn.LocalAddr().String(), Format: StatsDPlain})
+	require.NoError(t, err)
+
+	s.RunFinished(statsDJob, time.Second, 0)
+	s.Close()
+
+	assert.Equal(t, []string{
+		"job.nightly_backup.execution_time:1000.000|ms",
+		"job.nightly_backup.executions:1|c",
+		"job.nightly_backup.successful_executions:1|c",
+	}, lines(readPackets(t, conn)))
+}
+
+func TestStatsDBatchesPackets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dsd.socket")
+
+	conn, err := net.ListenPacket("unixgram", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewStatsD(StatsDConfig{URL: "unixgram://" + path, MaxPacketSize: 200, FlushInterval: time.Hour})
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		s.DeadlineExceeded(statsDJob)
+	}
+	s.Close()
+
+	packets := readPackets(t, conn)
+	assert.Greater(t, len(packets), 1)
+
+	n := 0
+	for _, p := range packets {
+		assert.LessOrEqual(t, len(p), 200)
+		n += len(strings.Split(p, "\n"))
+	}
+	assert.Equal(t, 20, n)
+}
+
+func TestStatsDFlushesPeriodically(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	s, err := NewStatsD(StatsDConfig{URL: "udp://" + conn.LocalAddr().String(), FlushInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer s.Close()
+
+	s.DeadlineExceeded(statsDJob)
+
+	assert.Len(t, readPackets(t, conn), 1)
+}
+
+func TestStatsDDoesNotBlock(t *testing.T) {
+	// Nothing listens on this socket
+	path := filepath.Join(t.TempDir(), "missing.socket")
+
+	s, err := NewStatsD(StatsDConfig{URL: "unixgram://" + path, MaxPacketSize: 100})
+	require.NoError(t, err)
+
+	t0 := time.Now()
+	for i := 0; i < 10000; i++ {
+		s.RunStarted(statsDJob)
+		s.RunFinished(statsDJob, time.Second, 0)
+		s.RunEnded(statsDJob)
+	}
+	assert.Less(t, time.Since(t0), 2*time.Second)
+
+	s.Close()
+	assert.Greater(t, s.Dropped(), uint64(0))
+
+	// Metrics recorded after closing are dropped
+	s.RunStarted(statsDJob)
+	s.Close()
+}
+
+func TestNewStatsDInvalidConfig(t *testing.T) {
+	_, err := NewStatsD(StatsDConfig{URL: "tcp://localhost:8125"})
+	assert.Error(t, err)
+
+	_, err = NewStatsD(StatsDConfig{URL: "udp://"})
+	assert.Error(t, err)
+
+	_, err = NewStatsD(StatsDConfig{URL: "udp://localhost:8125", Format: "graphite"})
+	assert.Error(t, err)
+}
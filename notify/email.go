@@ -0,0 +1,239 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+type MailOn string
+
+const (
+	MailAlways  MailOn = "always"
+	MailOutput  MailOn = "output"
+	MailFailure MailOn = "failure"
+)
+
+var (
+	// MailKinds are the events an Email notifier should be subscribed to.
+	MailKinds = []Kind{Success, Recovery, Failure}
+)
+
+func ParseMailOn(s string) (MailOn, error) {
+	switch MailOn(s) {
+	case MailAlways, MailOutput, MailFailure:
+		return MailOn(s), nil
+	}
+
+	return "", fmt.Errorf("unknown mail condition: %q (expected always, output or failure)", s)
+}
+
+// Email sends the output of jobs by email, like cron does with MAILTO.
+// Recipients come from the event (i.e. the job's "@mailto" annotation or the
+// crontab's MAILTO), falling back to To.
+type Email struct {
+	Addr     string
+	Username string
+	Password string
+	StartTLS bool
+	From     string
+	To       []string
+	On       MailOn
+	MaxSize  int
+	Attach   bool
+}
+
+func (e *Email) Notify(ctx context.Context, event *Event) error {
+	to := e.To
+	if event.MailTo != nil {
+		to = event.MailTo
+	}
+
+	if len(to) == 0 {
+		return nil
+	}
+
+	switch e.On {
+	case MailFailure:
+		if event.Kind != Failure {
+			return nil
+		}
+	case MailOutput:
+		if len(event.MailOutput) == 0 {
+			return nil
+		}
+	}
+
+	msg, err := e.message(event, to)
+	if err != nil {
+		return err
+	}
+
+	return e.send(ctx, to, msg)
+}
+
+func (e *Email) output(event *Event) string {
+	out := strings.Join(event.MailOutput, "\n")
+
+	if e.MaxSize > 0 && len(out) > e.MaxSize {
+		out = "(truncated)...\n" + LastBytes(out, e.MaxSize)
+	}
+
+	return out
+}
+
+func (e *Email) message(event *Event, to []string) ([]byte, error) {
+	var msg bytes.Buffer
+
+	subject := fmt.Sprintf("[supercronic] %s: job %s %s", event.Host, event.Job.Name, event.Kind)
+
+	headers := []string{
+		"From: " + e.From,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + event.Time.Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+	}
+
+	summary := fmt.Sprintf(
+		"Job:       %s\nSchedule:  %s\nCommand:   %s\nIteration: %d\nExit code: %d\nDuration:  %s\n",
+		event.Job.Name,
+		event.Job.Schedule,
+		event.Job.Command,
+		event.Iteration,
+		event.ExitCode,
+		event.Duration,
+	)
+
+	if event.OutputFile != "" {
+		summary += "Output:    " + event.OutputFile + "\n"
+	}
+
+	if event.Error != "" {
+		summary += "Error:     " + event.Error + "\n"
+	}
+
+	if !e.Attach {
+		headers = append(headers, "Content-Type: text/plain; charset=utf-8", "")
+		msg.WriteString(strings.Join(headers, "\r\n") + "\r\n")
+		msg.WriteString(crlf(summary + "\n" + e.output(event) + "\n"))
+		return msg.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&msg)
+
+	headers = append(headers, fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q", w.Boundary()), "")
+	msg.WriteString(strings.Join(headers, "\r\n") + "\r\n")
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(crlf(summary)))
+
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":        {"text/plain; charset=utf-8"},
+		"Content-Disposition": {fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("%s-%d.log", event.Job.Name, event.Iteration))},
+	})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(crlf(e.output(event) + "\n")))
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return msg.Bytes(), nil
+}
+
+func (e *Email) send(ctx context.Context, to []string, msg []byte) error {
+	host, _, err := net.SplitHostPort(e.Addr)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", e.Addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if e.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(e.From); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// ParseMailTo splits a MAILTO value into addresses, which may be separated by
+// commas or whitespace.
+func ParseMailTo(s string) []string {
+	to := []string{}
+
+	for _, addr := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		to = append(to, addr)
+	}
+
+	return to
+}
+
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
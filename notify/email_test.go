@@ -0,0 +1,256 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMail struct {
+	from string
+	to   []string
+	auth string
+	data string
+}
+
+// testSMTPServer is a minimal SMTP server that accepts every message.
+type testSMTPServer struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	mails []testMail
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testSMTPServer{listener: l}
+	go s.serve()
+
+	return s
+}
+
+func (s *testSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go s.handle(conn)
+	}
+}
+
+func (s *testSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	var m testMail
+	reply("220 localhost ESMTP test")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			b, _ := base64.StdEncoding.DecodeString(strings.Fields(line)[2])
+			m.auth = string(b)
+			reply("235 ok")
+		case "MAIL":
+			m.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			m.to = append(m.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			m.data = data.String()
+
+			s.mu.Lock()
+			s.mails = append(s.mails, m)
+			s.mu.Unlock()
+
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *testSMTPServer) received() []testMail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]testMail{}, s.mails...)
+}
+
+func (s *testSMTPServer) Close() {
+	s.listener.Close()
+}
+
+func TestEmailSendsOutput(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	defer srv.Close()
+
+	e := &Email{
+		Addr:     srv.listener.Addr().String(),
+		Username: "user",
+		Password: "secret",
+		From:     "cron@example.com",
+		To:       []string{"ops@example.com"},
+		On:       MailAlways,
+	}
+
+	err := e.Notify(context.Background(), testEvent())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	mails := srv.received()
+	if !assert.Len(t, mails, 1) {
+		return
+	}
+
+	assert.Equal(t, "cron@example.com", mails[0].from)
+	assert.Equal(t, []string{"ops@example.com"}, mails[0].to)
+	assert.Equal(t, "\x00user\x00secret", mails[0].auth)
+
+	msg, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "[supercronic] box: job backup failure", msg.Header.Get("Subject"))
+		assert.Contains(t, mails[0].data, "Exit code: 1")
+		assert.Contains(t, mails[0].data, "line 1\r\nline 2")
+	}
+}
+
+func TestEmailAttachesTruncatedOutput(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	defer srv.Close()
+
+	e := &Email{
+		Addr:    srv.listener.Addr().String(),
+		From:    "cron@example.com",
+		To:      []string{"ops@example.com"},
+		On:      MailAlways,
+		MaxSize: 6,
+		Attach:  true,
+	}
+
+	assert.Nil(t, e.Notify(context.Background(), testEvent()))
+
+	mails := srv.received()
+	if assert.Len(t, mails, 1) {
+		assert.Contains(t, mails[0].data, "Content-Type: multipart/mixed")
+		assert.Contains(t, mails[0].data, `attachment; filename="backup-4.log"`)
+		assert.Contains(t, mails[0].data, "(truncated)...\r\nline 2")
+		assert.NotContains(t, mails[0].data, "line 1")
+	}
+}
+
+func TestEmailTruncatesOnRuneBoundary(t *testing.T) {
+	e := &Email{MaxSize: 5}
+
+	event := testEvent()
+	event.MailOutput = []string{"abc→déf"}
+
+	// Cutting at 5 bytes would split "→"
+	assert.Equal(t, "(truncated)...\ndéf", e.output(event))
+}
+
+func TestEmailUsesMailOutput(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	defer srv.Close()
+
+	e := &Email{
+		Addr: srv.listener.Addr().String(),
+		From: "cron@example.com",
+		To:   []string{"ops@example.com"},
+		On:   MailAlways,
+	}
+
+	event := testEvent()
+	event.Output = []string{"tail"}
+	event.MailOutput = []string{"first line", "tail"}
+
+	assert.Nil(t, e.Notify(context.Background(), event))
+
+	if mails := srv.received(); assert.Len(t, mails, 1) {
+		assert.Contains(t, mails[0].data, "first line\r\ntail")
+	}
+}
+
+func TestEmailConditions(t *testing.T) {
+	srv := newTestSMTPServer(t)
+	defer srv.Close()
+
+	e := &Email{
+		Addr: srv.listener.Addr().String(),
+		From: "cron@example.com",
+		To:   []string{"ops@example.com"},
+	}
+
+	success := &Event{Kind: Success, Time: time.Now(), MailOutput: []string{"hi"}}
+	silent := &Event{Kind: Success, Time: time.Now(), Output: []string{"tail only"}}
+	disabled := &Event{Kind: Failure, Time: time.Now(), MailTo: []string{}}
+	override := &Event{Kind: Failure, Time: time.Now(), MailTo: []string{"dev@example.com"}}
+
+	e.On = MailFailure
+	assert.Nil(t, e.Notify(context.Background(), success))
+	assert.Nil(t, e.Notify(context.Background(), disabled))
+	assert.Len(t, srv.received(), 0)
+
+	assert.Nil(t, e.Notify(context.Background(), override))
+	if mails := srv.received(); assert.Len(t, mails, 1) {
+		assert.Equal(t, []string{"dev@example.com"}, mails[0].to)
+	}
+
+	e.On = MailOutput
+	assert.Nil(t, e.Notify(context.Background(), silent))
+	assert.Len(t, srv.received(), 1)
+
+	assert.Nil(t, e.Notify(context.Background(), success))
+	assert.Len(t, srv.received(), 2)
+}
+
+func TestParseMailTo(t *testing.T) {
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, ParseMailTo("a@example.com, b@example.com"))
+	assert.Equal(t, []string{}, ParseMailTo(""))
+}
@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aptible/supercronic/redact"
+	"github.com/sirupsen/logrus"
+)
+
+type Kind string
+
+const (
+	Success  Kind = "success"
+	Failure  Kind = "failure"
+	Recovery Kind = "recovery"
+	Timeout  Kind = "timeout"
+	Missed   Kind = "missed"
+)
+
+var (
+	DefaultKinds = []Kind{Failure, Recovery, Timeout, Missed}
+)
+
+func ParseKinds(s []string) ([]Kind, error) {
+	kinds := make([]Kind, 0, len(s))
+
+	for _, k := range s {
+		switch Kind(k) {
+		case Success, Failure, Recovery, Timeout, Missed:
+			kinds = append(kinds, Kind(k))
+		default:
+			return nil, fmt.Errorf("unknown notification event: %q", k)
+		}
+	}
+
+	return kinds, nil
+}
+
+type Job struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Command  string `json:"command"`
+	Position int    `json:"position"`
+}
+
+// Event describes something that happened to a job. Iteration, ExitCode,
+// Duration, Output and OutputFile are only meaningful for events about a
+// finished run.
+type Event struct {
+	Kind      Kind          `json:"event"`
+	Time      time.Time     `json:"time"`
+	Host      string        `json:"host,omitempty"`
+	Job       Job           `json:"job"`
+	Iteration uint64        `json:"iteration"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"-"`
+	Error     string        `json:"error,omitempty"`
+	Output    []string      `json:"output"`
+
+	// MailOutput is the output of the run sent by email. Unlike Output,
+	// which is the short tail kept for failure reports, it is bounded by
+	// the MaxSize of email notifiers.
+	MailOutput []string `json:"-"`
+
+	// OutputFile is the path of the file the output of the run was
+	// written to, if any.
+	OutputFile string `json:"output_file,omitempty"`
+
+	// MailTo overrides the recipients of email notifications when not nil.
+	MailTo []string `json:"-"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, event *Event) error
+}
+
+type subscription struct {
+	notifier Notifier
+	kinds    map[Kind]bool
+}
+
+// Dispatcher sends events to notifiers in the background, so that a slow
+// endpoint never delays a job. A nil *Dispatcher discards all events.
+type Dispatcher struct {
+	Redactor *redact.Redactor
+
+	subscriptions []subscription
+	logger        *logrus.Entry
+	timeout       time.Duration
+	host          string
+
+	mu      sync.Mutex
+	failing map[string]bool
+
+	wg sync.WaitGroup
+}
+
+func NewDispatcher(logger *logrus.Entry, timeout time.Duration) *Dispatcher {
+	host, _ := os.Hostname()
+
+	return &Dispatcher{
+		logger:  logger,
+		timeout: timeout,
+		host:    host,
+		failing: make(map[string]bool),
+	}
+}
+
+// Subscribe registers notifier for the given kinds of events.
+func (d *Dispatcher) Subscribe(notifier Notifier, kinds []Kind) {
+	s := subscription{notifier: notifier, kinds: make(map[Kind]bool)}
+	for _, k := range kinds {
+		s.kinds[k] = true
+	}
+
+	d.subscriptions = append(d.subscriptions, s)
+}
+
+// Dispatch sends event to every notifier subscribed to its kind. Success
+// events for a job whose previous run failed are sent as Recovery events.
+func (d *Dispatcher) Dispatch(event *Event) {
+	if d == nil || len(d.subscriptions) == 0 {
+		return
+	}
+
+	switch event.Kind {
+	case Success, Failure:
+		d.mu.Lock()
+		wasFailing := d.failing[event.Job.Name]
+		d.failing[event.Job.Name] = event.Kind == Failure
+		d.mu.Unlock()
+
+		if event.Kind == Success && wasFailing {
+			event.Kind = Recovery
+		}
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	event.Host = d.host
+	event.Job.Command = d.Redactor.Redact(event.Job.Command)
+	event.Error = d.Redactor.Redact(event.Error)
+	event.Output = d.Redactor.RedactAll(event.Output)
+	event.MailOutput = d.Redactor.RedactAll(event.MailOutput)
+
+	for _, s := range d.subscriptions {
+		if !s.kinds[event.Kind] {
+			continue
+		}
+
+		d.wg.Add(1)
+
+		go func(notifier Notifier) {
+			defer d.wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+			defer cancel()
+
+			if err := notifier.Notify(ctx, event); err != nil {
+				d.logger.WithFields(logrus.Fields{
+					"job.name": event.Job.Name,
+					"event":    string(event.Kind),
+				}).Errorf("failed to send notification: %v", err)
+			}
+		}(s.notifier)
+	}
+}
+
+// Wait blocks until all notifications that are in flight have been sent.
+func (d *Dispatcher) Wait() {
+	if d == nil {
+		return
+	}
+
+	d.wg.Wait()
+}
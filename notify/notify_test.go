@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/redact"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type testNotifier struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (n *testNotifier) Notify(ctx context.Context, event *Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.events = append(n.events, *event)
+	return nil
+}
+
+func (n *testNotifier) kinds() []Kind {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	kinds := []Kind{}
+	for _, e := range n.events {
+		kinds = append(kinds, e.Kind)
+	}
+	return kinds
+}
+
+func newTestDispatcher() *Dispatcher {
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	return NewDispatcher(logrus.NewEntry(logger), time.Second)
+}
+
+func TestDispatcherSendsRecovery(t *testing.T) {
+	d := newTestDispatcher()
+	n := &testNotifier{}
+	d.Subscribe(n, DefaultKinds)
+
+	job := Job{Name: "foo"}
+
+	for _, kind := range []Kind{Success, Failure, Failure, Success, Success, Timeout} {
+		d.Dispatch(&Event{Kind: kind, Job: job})
+		d.Wait()
+	}
+
+	assert.Equal(t, []Kind{Failure, Failure, Recovery, Timeout}, n.kinds())
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Dispatch(&Event{Kind: Failure})
+	d.Wait()
+}
+
+func TestParseKinds(t *testing.T) {
+	kinds, err := ParseKinds([]string{"failure", "missed"})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []Kind{Failure, Missed}, kinds)
+	}
+
+	_, err = ParseKinds([]string{"nope"})
+	assert.NotNil(t, err)
+}
+
+func testEvent() *Event {
+	return &Event{
+		Kind:      Failure,
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Host:      "box",
+		Job:       Job{Name: "backup", Schedule: "@daily", Command: "backup.sh \"quoted\"", Position: 2},
+		Iteration: 4,
+		ExitCode:  1,
+		Duration:  1500 * time.Millisecond,
+		Error:     "error running command: exit status 1",
+		Output:    []string{"line 1", "line 2"},
+
+		MailOutput: []string{"line 1", "line 2"},
+	}
+}
+
+func TestWebhookTemplatesRenderValidJSON(t *testing.T) {
+	for _, name := range []string{"json", "slack", "teams"} {
+		var body []byte
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"), name)
+			body, _ = io.ReadAll(r.Body)
+		}))
+
+		tmpl, err := LoadTemplate(name)
+		if !assert.Nil(t, err, name) {
+			srv.Close()
+			continue
+		}
+
+		err = NewWebhook(srv.URL, tmpl).Notify(context.Background(), testEvent())
+		assert.Nil(t, err, name)
+		srv.Close()
+
+		var payload map[string]interface{}
+		assert.Nil(t, json.Unmarshal(body, &payload), "%s: %s", name, body)
+	}
+}
+
+func TestWebhookDefaultPayload(t *testing.T) {
+	var payload map[string]interface{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&payload))
+	}))
+	defer srv.Close()
+
+	tmpl, _ := LoadTemplate("json")
+	assert.Nil(t, NewWebhook(srv.URL, tmpl).Notify(context.Background(), testEvent()))
+
+	assert.Equal(t, "failure", payload["event"])
+	assert.Equal(t, 1.5, payload["duration_seconds"])
+	assert.Equal(t, 1.0, payload["exit_code"])
+	assert.Equal(t, []interface{}{"line 1", "line 2"}, payload["output"])
+	assert.Equal(t, "backup", payload["job"].(map[string]interface{})["name"])
+}
+
+func TestWebhookRetries(t *testing.T) {
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	tmpl, _ := LoadTemplate("json")
+	w := NewWebhook(srv.URL, tmpl)
+	w.Backoff = time.Millisecond
+
+	assert.Nil(t, w.Notify(context.Background(), testEvent()))
+	assert.Equal(t, 3, attempts)
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	tmpl, _ := LoadTemplate("json")
+	w := NewWebhook(srv.URL, tmpl)
+	w.Backoff = time.Millisecond
+
+	assert.NotNil(t, w.Notify(context.Background(), testEvent()))
+	assert.Equal(t, 1, attempts)
+}
+
+func TestWebhookErrorsHideURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	tmpl, _ := LoadTemplate("json")
+	w := NewWebhook(srv.URL+"/hooks/s3cr3t-token", tmpl)
+	w.Retries = 0
+
+	err := w.Notify(context.Background(), testEvent())
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "s3cr3t-token")
+		assert.Contains(t, err.Error(), srv.Listener.Addr().String())
+	}
+
+	w = NewWebhook("http://example.com/hooks/s3cr3t-token\x7f", tmpl)
+	err = w.Notify(context.Background(), testEvent())
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "s3cr3t-token")
+	}
+}
+
+func TestDispatcherRedactsEvents(t *testing.T) {
+	d := newTestDispatcher()
+	d.Redactor = redact.New(nil)
+	d.Redactor.SetSecrets([]string{"s3cr3t"})
+
+	n := &testNotifier{}
+	d.Subscribe(n, DefaultKinds)
+
+	d.Dispatch(&Event{
+		Kind:   Failure,
+		Job:    Job{Name: "foo", Command: "deploy --token s3cr3t"},
+		Error:  "failed with s3cr3t",
+		Output: []string{"token is s3cr3t"},
+	})
+	d.Wait()
+
+	if assert.Len(t, n.events, 1) {
+		assert.Equal(t, "deploy --token [REDACTED]", n.events[0].Job.Command)
+		assert.Equal(t, "failed with [REDACTED]", n.events[0].Error)
+		assert.Equal(t, []string{"token is [REDACTED]"}, n.events[0].Output)
+	}
+}
@@ -0,0 +1,229 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aptible/supercronic/redact"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	MaxPingBodySize = 10 * 1024
+)
+
+// Pinger sends Healthchecks-style pings: "<url>/start" when a job starts,
+// "<url>" when it succeeds and "<url>/fail" when it fails. The query of the
+// URL, if any, is kept. Pings are sent in
+// the background, so a slow endpoint never delays the scheduler. A nil
+// *Pinger sends nothing.
+type Pinger struct {
+	Client  *http.Client
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+
+	Redactor *redact.Redactor
+
+	logger *logrus.Entry
+	wg     sync.WaitGroup
+}
+
+func NewPinger(logger *logrus.Entry, timeout time.Duration, retries int) *Pinger {
+	return &Pinger{
+		Client:  http.DefaultClient,
+		Timeout: timeout,
+		Retries: retries,
+		Backoff: time.Second,
+		logger:  logger,
+	}
+}
+
+// PingRun tracks the pings of a single run. Finish pings are sent after the
+// start ping has completed, so that they reach the endpoint in order.
+type PingRun struct {
+	pinger  *Pinger
+	url     *url.URL
+	rid     string
+	started chan struct{}
+}
+
+// Start sends the start ping for a run of the job pinging pingURL.
+func (p *Pinger) Start(pingURL string) *PingRun {
+	if p == nil || pingURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(pingURL)
+	if err != nil {
+		// Leave the URL out of the error: ping URLs are secrets
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		p.logger.Errorf("invalid ping url: %v", err)
+		return nil
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	run := &PingRun{
+		pinger:  p,
+		url:     u,
+		rid:     newRunID(),
+		started: make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+		defer close(run.started)
+
+		p.send(run.url.JoinPath("start"), run.rid, nil)
+	}()
+
+	return run
+}
+
+// Finish sends the success or fail ping for the run. Failures include the
+// exit code and the (truncated) output of the job in the ping body.
+func (r *PingRun) Finish(exitCode int, output []string) {
+	if r == nil {
+		return
+	}
+
+	target := r.url.JoinPath()
+	var body []byte
+
+	if exitCode != 0 {
+		target = r.url.JoinPath("fail")
+		body = pingBody(exitCode, r.pinger.Redactor.RedactAll(output))
+	}
+
+	r.pinger.wg.Add(1)
+
+	go func() {
+		defer r.pinger.wg.Done()
+
+		<-r.started
+		r.pinger.send(target, r.rid, body)
+	}()
+}
+
+// Wait blocks until all pings that are in flight have been sent.
+func (p *Pinger) Wait() {
+	if p == nil {
+		return
+	}
+
+	p.wg.Wait()
+}
+
+// send pings target, which it modifies to add the run ID to its query.
+func (p *Pinger) send(target *url.URL, rid string, body []byte) {
+	q := target.Query()
+	q.Set("rid", rid)
+	target.RawQuery = q.Encode()
+
+	backoff := p.Backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := p.post(target.String(), body)
+		if err == nil {
+			return
+		}
+
+		if !retry || attempt >= p.Retries {
+			p.logger.Warnf("failed to send ping: %v", err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *Pinger) post(target string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		// Strip the URL from the error: ping URLs are secrets
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("ping returned %s", resp.Status)
+}
+
+func pingBody(exitCode int, output []string) []byte {
+	header := fmt.Sprintf("exit code: %d\n\n", exitCode)
+	out := strings.Join(output, "\n")
+
+	if len(header)+len(out) > MaxPingBodySize {
+		// Keep the end of the output, it's usually the most relevant
+		// part when a job fails.
+		marker := "(truncated)...\n"
+
+		keep := MaxPingBodySize - len(header) - len(marker)
+		if keep < 0 {
+			keep = 0
+		}
+
+		out = marker + LastBytes(out, keep)
+	}
+
+	return []byte(header + out)
+}
+
+// LastBytes returns the end of s, at most max bytes long, without cutting a
+// UTF-8 character in half.
+func LastBytes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	i := len(s) - max
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+
+	return s[i:]
+}
+
+func newRunID() string {
+	var b [16]byte
+	rand.Read(b[:])
+
+	// RFC 4122 version 4 UUID
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
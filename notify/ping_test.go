@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type pingRequest struct {
+	path  string
+	rid   string
+	body  string
+	query url.Values
+}
+
+func newPingServer(delay time.Duration) (*httptest.Server, func() []pingRequest) {
+	var mu sync.Mutex
+	requests := []pingRequest{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, pingRequest{r.URL.Path, r.URL.Query().Get("rid"), string(body), r.URL.Query()})
+	}))
+
+	return srv, func() []pingRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]pingRequest{}, requests...)
+	}
+}
+
+func newTestPinger() *Pinger {
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	p := NewPinger(logrus.NewEntry(logger), time.Second, 2)
+	p.Backoff = time.Millisecond
+	return p
+}
+
+func TestPingerSuccess(t *testing.T) {
+	srv, requests := newPingServer(0)
+	defer srv.Close()
+
+	p := newTestPinger()
+	p.Start(srv.URL+"/abc/").Finish(0, []string{"hello"})
+	p.Wait()
+
+	r := requests()
+	if assert.Len(t, r, 2) {
+		assert.Equal(t, "/abc/start", r[0].path)
+		assert.Equal(t, "/abc", r[1].path)
+		assert.Equal(t, "", r[1].body)
+		assert.NotEmpty(t, r[0].rid)
+		assert.Equal(t, r[0].rid, r[1].rid)
+	}
+}
+
+func TestPingerFailure(t *testing.T) {
+	srv, requests := newPingServer(0)
+	defer srv.Close()
+
+	p := newTestPinger()
+	p.Start(srv.URL).Finish(2, []string{"some", "output"})
+	p.Wait()
+
+	r := requests()
+	if assert.Len(t, r, 2) {
+		assert.Equal(t, "/fail", r[1].path)
+		assert.Equal(t, "exit code: 2\n\nsome\noutput", r[1].body)
+	}
+}
+
+func TestPingerKeepsQuery(t *testing.T) {
+	srv, requests := newPingServer(0)
+	defer srv.Close()
+
+	p := newTestPinger()
+	p.Start(srv.URL+"/ping/uuid?create=1").Finish(1, nil)
+	p.Wait()
+
+	r := requests()
+	if assert.Len(t, r, 2) {
+		assert.Equal(t, "/ping/uuid/start", r[0].path)
+		assert.Equal(t, "/ping/uuid/fail", r[1].path)
+		for _, req := range r {
+			assert.Equal(t, "1", req.query.Get("create"))
+			assert.Equal(t, r[0].rid, req.rid)
+		}
+	}
+}
+
+func TestPingerDoesNotBlock(t *testing.T) {
+	srv, requests := newPingServer(500 * time.Millisecond)
+	defer srv.Close()
+
+	p := newTestPinger()
+
+	t0 := time.Now()
+	p.Start(srv.URL).Finish(0, nil)
+	assert.True(t, time.Since(t0) < 100*time.Millisecond)
+
+	p.Wait()
+
+	r := requests()
+	if assert.Len(t, r, 2) {
+		assert.Equal(t, "/start", r[0].path)
+		assert.Equal(t, "/", r[1].path)
+	}
+}
+
+func TestPingerRetries(t *testing.T) {
+	attempts := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	p := newTestPinger()
+	p.Start(srv.URL)
+	p.Wait()
+
+	assert.Equal(t, 3, attempts)
+}
+
+func TestNilPinger(t *testing.T) {
+	var p *Pinger
+	p.Start("http://example.com").Finish(1, nil)
+	p.Wait()
+
+	newTestPinger().Start("").Finish(1, nil)
+}
+
+func TestPingBodyIsTruncated(t *testing.T) {
+	output := []string{strings.Repeat("a", MaxPingBodySize), "the end"}
+
+	body := string(pingBody(1, output))
+
+	assert.Len(t, body, MaxPingBodySize)
+	assert.True(t, strings.HasPrefix(body, "exit code: 1\n\n(truncated)...\n"))
+	assert.True(t, strings.HasSuffix(body, "\nthe end"))
+}
+
+func TestPingBodyIsTruncatedOnRuneBoundary(t *testing.T) {
+	output := []string{strings.Repeat("é", MaxPingBodySize)}
+
+	body := pingBody(1, output)
+
+	assert.LessOrEqual(t, len(body), MaxPingBodySize)
+	assert.True(t, utf8.Valid(body))
+}
+
+func TestLastBytesKeepsRunesWhole(t *testing.T) {
+	assert.Equal(t, "abc", LastBytes("abc", 10))
+	assert.Equal(t, "bc", LastBytes("abc", 2))
+	assert.Equal(t, "é", LastBytes("aéé", 3))
+}
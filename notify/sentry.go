@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// sentryShorthands maps the schedule shorthands supported in crontabs to
+// their five field equivalent.
+var sentryShorthands = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// SentryCrontab returns the five field crontab schedule Sentry expects for a
+// job schedule. Schedules with a seconds field are only supported if it is
+// "0", and schedules with a years field only if it is "*".
+func SentryCrontab(schedule string) (string, bool) {
+	if crontab, ok := sentryShorthands[strings.ToLower(schedule)]; ok {
+		return crontab, true
+	}
+
+	fields := strings.Fields(schedule)
+
+	switch len(fields) {
+	case 5:
+		return strings.Join(fields, " "), true
+	case 6:
+		// minute hour dom month dow year
+		if fields[5] == "*" {
+			return strings.Join(fields[:5], " "), true
+		}
+	case 7:
+		// second minute hour dom month dow year
+		if fields[0] == "0" && fields[6] == "*" {
+			return strings.Join(fields[1:6], " "), true
+		}
+	}
+
+	return "", false
+}
+
+var invalidSlugChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// SentrySlug returns the monitor slug of a job name: lowercase letters,
+// digits, dashes and underscores, at most 50 characters long.
+func SentrySlug(name string) string {
+	slug := strings.Trim(invalidSlugChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+
+	if len(slug) > 50 {
+		slug = strings.TrimRight(slug[:50], "-")
+	}
+
+	return slug
+}
+
+// localtimePath is the file that sets the local timezone of the machine.
+var localtimePath = "/etc/localtime"
+
+// SentryTimezone returns the IANA name of timezone sent with monitor
+// configs. time.Local is named "Local", which Sentry does not understand: its
+// name is read from $TZ or the /etc/localtime link instead. An empty name,
+// which leaves the timezone of the monitor unset, is returned if it cannot be
+// found.
+func SentryTimezone(timezone *time.Location) string {
+	if timezone == nil {
+		return ""
+	}
+
+	if timezone != time.Local {
+		return timezone.String()
+	}
+
+	if tz, ok := os.LookupEnv("TZ"); ok {
+		tz = strings.TrimPrefix(tz, ":")
+		if tz == "" {
+			return "UTC"
+		}
+
+		if _, err := time.LoadLocation(tz); err == nil && !filepath.IsAbs(tz) {
+			return tz
+		}
+	}
+
+	if target, err := os.Readlink(localtimePath); err == nil {
+		if _, name, ok := strings.Cut(target, "zoneinfo/"); ok {
+			if _, err := time.LoadLocation(name); err == nil {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// SentryMonitor sends Sentry Cron Monitor check-ins: in_progress when a job
+// starts, then ok or error when it finishes. Monitors are created or updated
+// with the schedule and timezone of the job. A nil *SentryMonitor sends
+// nothing.
+type SentryMonitor struct {
+	Client *sentry.Client
+}
+
+func NewSentryMonitor(client *sentry.Client) *SentryMonitor {
+	return &SentryMonitor{Client: client}
+}
+
+// SentryCheckIn tracks the check-ins of a single run.
+type SentryCheckIn struct {
+	monitor *SentryMonitor
+	slug    string
+	id      sentry.EventID
+	config  *sentry.MonitorConfig
+	start   time.Time
+}
+
+// Start sends the in_progress check-in of a run of the monitor slug.
+func (m *SentryMonitor) Start(slug string, schedule string, timezone *time.Location) *SentryCheckIn {
+	if m == nil || slug == "" {
+		return nil
+	}
+
+	checkIn := &SentryCheckIn{
+		monitor: m,
+		slug:    slug,
+		start:   time.Now(),
+	}
+
+	if crontab, ok := SentryCrontab(schedule); ok {
+		checkIn.config = &sentry.MonitorConfig{Schedule: sentry.CrontabSchedule(crontab)}
+		checkIn.config.Timezone = SentryTimezone(timezone)
+	}
+
+	id := m.Client.CaptureCheckIn(&sentry.CheckIn{
+		MonitorSlug: slug,
+		Status:      sentry.CheckInStatusInProgress,
+	}, checkIn.config, nil)
+
+	if id != nil {
+		checkIn.id = *id
+	}
+
+	return checkIn
+}
+
+// Finish sends the ok or error check-in of the run.
+func (c *SentryCheckIn) Finish(exitCode int) {
+	if c == nil {
+		return
+	}
+
+	status := sentry.CheckInStatusOK
+	if exitCode != 0 {
+		status = sentry.CheckInStatusError
+	}
+
+	c.monitor.Client.CaptureCheckIn(&sentry.CheckIn{
+		ID:          c.id,
+		MonitorSlug: c.slug,
+		Status:      status,
+		Duration:    time.Since(c.start),
+	}, c.config, nil)
+}
+
+// Wait blocks until all check-ins have been sent, or timeout.
+func (m *SentryMonitor) Wait(timeout time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.Client.Flush(timeout)
+}
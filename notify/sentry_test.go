@@ -0,0 +1,193 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSentry is a Sentry endpoint that returns the check-ins it receives.
+func fakeSentry(t *testing.T) (string, chan map[string]interface{}) {
+	checkIns := make(chan map[string]interface{}, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+
+		// Skip the envelope header, then read item headers and
+		// payloads
+		scanner.Scan()
+		for scanner.Scan() {
+			var header map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || !scanner.Scan() {
+				break
+			}
+
+			var payload map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &payload); err == nil && header["type"] == "check_in" {
+				checkIns <- payload
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+
+	return strings.Replace(srv.URL, "http://", "http://public@", 1) + "/1", checkIns
+}
+
+func TestSentryMonitorCheckIns(t *testing.T) {
+	dsn, checkIns := fakeSentry(t)
+
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: dsn})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	monitor := NewSentryMonitor(client)
+
+	tz, err := time.LoadLocation("Europe/Paris")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	for _, exitCode := range []int{0, 3} {
+		checkIn := monitor.Start("backup", "@hourly", tz)
+		checkIn.Finish(exitCode)
+		monitor.Wait(time.Second)
+
+		var received []map[string]interface{}
+		for len(received) < 2 {
+			select {
+			case c := <-checkIns:
+				received = append(received, c)
+			case <-time.After(3 * time.Second):
+				t.Fatalf("timed out waiting for check-ins")
+			}
+		}
+
+		status := "ok"
+		if exitCode != 0 {
+			status = "error"
+		}
+
+		assert.Equal(t, "in_progress", received[0]["status"])
+		assert.Equal(t, status, received[1]["status"])
+
+		for _, c := range received {
+			assert.Equal(t, "backup", c["monitor_slug"])
+			assert.Equal(t, received[0]["check_in_id"], c["check_in_id"])
+			assert.Equal(t, map[string]interface{}{
+				"schedule": map[string]interface{}{"type": "crontab", "value": "0 * * * *"},
+				"timezone": "Europe/Paris",
+			}, c["monitor_config"])
+		}
+		assert.Contains(t, received[1], "duration")
+	}
+}
+
+func TestSentryMonitorDefaultTimezone(t *testing.T) {
+	dsn, checkIns := fakeSentry(t)
+
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: dsn})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	monitor := NewSentryMonitor(client)
+
+	// Crontabs without a TZ use time.Local, whose name must not be sent
+	// as is when it cannot be resolved
+	t.Setenv("TZ", "/nonexistent")
+	defer func(path string) { localtimePath = path }(localtimePath)
+	localtimePath = filepath.Join(t.TempDir(), "localtime")
+
+	monitor.Start("backup", "@hourly", time.Local).Finish(0)
+	monitor.Wait(time.Second)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case c := <-checkIns:
+			assert.Equal(t, map[string]interface{}{
+				"schedule": map[string]interface{}{"type": "crontab", "value": "0 * * * *"},
+			}, c["monitor_config"])
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timed out waiting for check-ins")
+		}
+	}
+}
+
+func TestSentryTimezone(t *testing.T) {
+	defer func(path string) { localtimePath = path }(localtimePath)
+	localtimePath = filepath.Join(t.TempDir(), "localtime")
+
+	tz, err := time.LoadLocation("Europe/Paris")
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	assert.Equal(t, "", SentryTimezone(nil))
+	assert.Equal(t, "Europe/Paris", SentryTimezone(tz))
+	assert.Equal(t, "UTC", SentryTimezone(time.UTC))
+
+	t.Setenv("TZ", "America/New_York")
+	assert.Equal(t, "America/New_York", SentryTimezone(time.Local))
+
+	t.Setenv("TZ", ":Asia/Tokyo")
+	assert.Equal(t, "Asia/Tokyo", SentryTimezone(time.Local))
+
+	t.Setenv("TZ", "")
+	assert.Equal(t, "UTC", SentryTimezone(time.Local))
+
+	t.Setenv("TZ", "Not/AZone")
+	assert.Equal(t, "", SentryTimezone(time.Local))
+
+	if assert.Nil(t, os.Symlink("/usr/share/zoneinfo/Europe/Paris", localtimePath)) {
+		assert.Equal(t, "Europe/Paris", SentryTimezone(time.Local))
+	}
+}
+
+func TestSentryMonitorDisabled(t *testing.T) {
+	var monitor *SentryMonitor
+
+	checkIn := monitor.Start("backup", "@hourly", time.UTC)
+	assert.Nil(t, checkIn)
+	checkIn.Finish(0)
+	monitor.Wait(time.Second)
+
+	monitor = NewSentryMonitor(nil)
+	assert.Nil(t, monitor.Start("", "@hourly", time.UTC))
+}
+
+func TestSentryCrontab(t *testing.T) {
+	for schedule, expected := range map[string]string{
+		"*/5 * * * *":       "*/5 * * * *",
+		"@daily":            "0 0 * * *",
+		"0 12 * * 1-5 *":    "0 12 * * 1-5",
+		"0 30 2 * * * *":    "30 2 * * *",
+		"  15  3 * * *  ":   "15 3 * * *",
+		"*/10 * * * * * *":  "",
+		"0 0 1 1 * 2030":    "",
+		"@every 5m":         "",
+		"* * * *":           "",
+		"0 0 0 1 1 * 2030 ": "",
+	} {
+		crontab, ok := SentryCrontab(schedule)
+		assert.Equal(t, expected != "", ok, schedule)
+		assert.Equal(t, expected, crontab, schedule)
+	}
+}
+
+func TestSentrySlug(t *testing.T) {
+	assert.Equal(t, "nightly-backup", SentrySlug("Nightly Backup!"))
+	assert.Equal(t, "job-3", SentrySlug("job-3"))
+	assert.Len(t, SentrySlug(strings.Repeat("a", 100)), 50)
+}
@@ -0,0 +1,188 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join": strings.Join,
+	"seconds": func(d time.Duration) float64 {
+		return d.Seconds()
+	},
+}
+
+var builtinTemplates = map[string]string{
+	"json": `{
+  "event": {{ json .Kind }},
+  "time": {{ json .Time }},
+  "host": {{ json .Host }},
+  "job": {{ json .Job }},
+  "iteration": {{ .Iteration }},
+  "exit_code": {{ .ExitCode }},
+  "duration_seconds": {{ seconds .Duration }},
+  "error": {{ json .Error }},
+  "output": {{ json .Output }}{{ if .OutputFile }},
+  "output_file": {{ json .OutputFile }}{{ end }}
+}`,
+
+	"slack": `{
+  "text": {{ json (printf "[%s] job %s: %s" .Host .Job.Name .Kind) }},
+  "blocks": [
+    {
+      "type": "section",
+      "text": {
+        "type": "mrkdwn",
+        "text": {{ json (printf "*%s*: job ` + "`%s`" + ` (` + "`%s`" + `)\n%s" .Kind .Job.Name .Job.Schedule .Error) }}
+      }
+    }{{ if .Output }},
+    {
+      "type": "section",
+      "text": {
+        "type": "mrkdwn",
+        "text": {{ json (printf "` + "```%s```" + `" (join .Output "\n")) }}
+      }
+    }{{ end }}
+  ]
+}`,
+
+	"teams": `{
+  "@type": "MessageCard",
+  "@context": "https://schema.org/extensions",
+  "summary": {{ json (printf "job %s: %s" .Job.Name .Kind) }},
+  "themeColor": {{ if eq .Kind "recovery" "success" }}"2EB886"{{ else }}"D00000"{{ end }},
+  "title": {{ json (printf "[%s] job %s: %s" .Host .Job.Name .Kind) }},
+  "sections": [
+    {
+      "facts": [
+        {"name": "Schedule", "value": {{ json .Job.Schedule }}},
+        {"name": "Command", "value": {{ json .Job.Command }}},
+        {"name": "Exit code", "value": "{{ .ExitCode }}"},
+        {"name": "Duration", "value": {{ json (printf "%s" .Duration) }}}{{ if .OutputFile }},
+        {"name": "Output file", "value": {{ json .OutputFile }}}{{ end }}
+      ],
+      "text": {{ json (printf "%s\n\n%s" .Error (join .Output "\n\n")) }}
+    }
+  ]
+}`,
+}
+
+// Webhook POSTs a JSON body rendered from a template to a URL. Requests that
+// fail with a network error or a 5xx or 429 status are retried with
+// exponential backoff.
+type Webhook struct {
+	URL      string
+	Template *template.Template
+	Client   *http.Client
+	Retries  int
+	Backoff  time.Duration
+}
+
+// LoadTemplate returns one of the builtin templates ("json", "slack" or
+// "teams"), or parses the Go template found at path otherwise.
+func LoadTemplate(nameOrPath string) (*template.Template, error) {
+	if nameOrPath == "" {
+		nameOrPath = "json"
+	}
+
+	text, ok := builtinTemplates[nameOrPath]
+	if !ok {
+		b, err := os.ReadFile(nameOrPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read webhook template: %w", err)
+		}
+		text = string(b)
+	}
+
+	return template.New(nameOrPath).Funcs(templateFuncs).Parse(text)
+}
+
+func NewWebhook(url string, tmpl *template.Template) *Webhook {
+	return &Webhook{
+		URL:      url,
+		Template: tmpl,
+		Client:   http.DefaultClient,
+		Retries:  3,
+		Backoff:  time.Second,
+	}
+}
+
+func (w *Webhook) Notify(ctx context.Context, event *Event) error {
+	var body bytes.Buffer
+	if err := w.Template.Execute(&body, event); err != nil {
+		return fmt.Errorf("failed to render webhook body: %w", err)
+	}
+
+	backoff := w.Backoff
+
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body.Bytes())
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= w.Retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, w.stripURL(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true, w.stripURL(err)
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	// The URL is left out of the error on purpose: webhook URLs usually
+	// embed a secret token.
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// stripURL removes the URL from errors of the HTTP client, keeping only its
+// host: webhook URLs usually embed a secret token.
+func (w *Webhook) stripURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	u, parseErr := url.Parse(w.URL)
+	if parseErr != nil {
+		return fmt.Errorf("invalid webhook URL: %w", err)
+	}
+
+	return fmt.Errorf("webhook to %s failed: %w", u.Host, err)
+}
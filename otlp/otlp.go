@@ -0,0 +1,51 @@
+// Package otlp holds the settings shared by the OTLP exporters of traces and
+// metrics.
+package otlp
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// InstrumentationName is the name of the tracer and meter of supercronic.
+const InstrumentationName = "github.com/aptible/supercronic"
+
+type Protocol string
+
+const (
+	ProtocolHTTP Protocol = "http"
+	ProtocolGRPC Protocol = "grpc"
+)
+
+func ParseProtocol(s string) (Protocol, error) {
+	switch Protocol(s) {
+	case ProtocolHTTP, ProtocolGRPC:
+		return Protocol(s), nil
+	default:
+		return "", fmt.Errorf("unknown OTLP protocol: %q", s)
+	}
+}
+
+// Resource returns the resource telemetry is exported as: a service named
+// "supercronic", unless OTEL_SERVICE_NAME says otherwise.
+func Resource() *resource.Resource {
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(attribute.String("service.name", "supercronic")),
+	)
+	if err != nil {
+		return resource.Default()
+	}
+
+	// OTEL_SERVICE_NAME takes precedence over our default
+	if env, err := resource.New(context.Background(), resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, env); err == nil {
+			res = merged
+		}
+	}
+
+	return res
+}
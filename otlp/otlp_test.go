@@ -0,0 +1,21 @@
+package otlp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProtocol(t *testing.T) {
+	p, err := ParseProtocol("grpc")
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolGRPC, p)
+
+	p, err = ParseProtocol("http")
+	require.NoError(t, err)
+	assert.Equal(t, ProtocolHTTP, p)
+
+	_, err = ParseProtocol("udp")
+	assert.Error(t, err)
+}
@@ -5,19 +5,39 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"time"
 
+	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/metrics"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/sirupsen/logrus"
 )
 
 const (
-	DefaultPort = "9746"
-	namespace   = "supercronic"
+	DefaultPort      = "9746"
+	DefaultNamespace = metrics.DefaultNamespace
 )
 
-func genMetricName(name string) string {
-	return prometheus.BuildFQName(namespace, "", name)
+// DefaultBuckets are the buckets of the execution time histogram, in
+// seconds.
+var DefaultBuckets = metrics.DefaultBuckets
+
+// Options configures the metrics created by NewPrometheusMetrics. Namespace
+// prefixes the name of every metric, and ConstLabels are added to all of
+// them. Buckets are the buckets of the execution time histogram, which is
+// also exposed as a native histogram if NativeHistogramBucketFactor is set.
+// RuntimeMetrics adds the Go and process collectors. LabelMode selects the
+// labels identifying jobs, and defaults to metrics.LabelsFull.
+type Options struct {
+	Namespace                   string
+	LabelMode                   metrics.LabelMode
+	ConstLabels                 prometheus.Labels
+	Buckets                     []float64
+	NativeHistogramBucketFactor float64
+	RuntimeMetrics              bool
 }
 
 type PrometheusMetrics struct {
@@ -27,78 +47,325 @@ type PrometheusMetrics struct {
 	CronsFailCounter             prometheus.CounterVec
 	CronsDeadlineExceededCounter prometheus.CounterVec
 	CronsExecutionTimeHistogram  prometheus.HistogramVec
+	CronsHookFailCounter         prometheus.CounterVec
+	CronsOutputDroppedCounter    prometheus.CounterVec
+
+	// These gauges describe the last run of each job
+	CronsLastStartGauge    prometheus.GaugeVec
+	CronsLastSuccessGauge  prometheus.GaugeVec
+	CronsLastFailureGauge  prometheus.GaugeVec
+	CronsLastDurationGauge prometheus.GaugeVec
+	CronsLastExitCodeGauge prometheus.GaugeVec
+	CronsNextRunGauge      prometheus.GaugeVec
+
+	labelMode   metrics.LabelMode
+	constLabels prometheus.Labels
 }
 
-func NewPrometheusMetrics() PrometheusMetrics {
-	cronLabels := []string{"command", "position", "schedule"}
+func NewPrometheusMetrics(registerer prometheus.Registerer, opts Options) (*PrometheusMetrics, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
 
-	pm := PrometheusMetrics{}
+	if opts.Buckets == nil {
+		opts.Buckets = DefaultBuckets
+	}
+
+	genMetricName := func(name string) string {
+		return prometheus.BuildFQName(opts.Namespace, "", name)
+	}
+
+	if opts.LabelMode == "" {
+		opts.LabelMode = metrics.LabelsFull
+	}
+
+	cronLabels := opts.LabelMode.Names()
+
+	pm := &PrometheusMetrics{labelMode: opts.LabelMode, constLabels: opts.ConstLabels}
 
 	pm.CronsCurrentlyRunningGauge = *prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: genMetricName("currently_running"),
-			Help: "count of currently running cron executions",
+			Name:        genMetricName("currently_running"),
+			Help:        opts.LabelMode.Help("count of currently running cron executions"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsCurrentlyRunningGauge)
 
 	pm.CronsExecCounter = *prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: genMetricName("executions"),
-			Help: "count of cron executions",
+			Name:        genMetricName("executions"),
+			Help:        opts.LabelMode.Help("count of cron executions"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsExecCounter)
 
 	pm.CronsSuccessCounter = *prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: genMetricName("successful_executions"),
-			Help: "count of successul cron executions",
+			Name:        genMetricName("successful_executions"),
+			Help:        opts.LabelMode.Help("count of successul cron executions"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsSuccessCounter)
 
 	pm.CronsFailCounter = *prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: genMetricName("failed_executions"),
-			Help: "count of failed cron executions",
+			Name:        genMetricName("failed_executions"),
+			Help:        opts.LabelMode.Help("count of failed cron executions"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsFailCounter)
 
 	pm.CronsDeadlineExceededCounter = *prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: genMetricName("deadline_exceeded"),
-			Help: "count of exceeded deadline cron executions",
+			Name:        genMetricName("deadline_exceeded"),
+			Help:        opts.LabelMode.Help("count of exceeded deadline cron executions"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsDeadlineExceededCounter)
 
 	pm.CronsExecutionTimeHistogram = *prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    genMetricName("cron_execution_time_seconds"),
-			Help:    "duration of the cron executions",
-			Buckets: []float64{10.0, 30.0, 60.0, 120.0, 300.0, 600.0, 1800.0, 3600.0},
+			Name:                        genMetricName("cron_execution_time_seconds"),
+			Help:                        opts.LabelMode.Help("duration of the cron executions"),
+			ConstLabels:                 opts.ConstLabels,
+			Buckets:                     opts.Buckets,
+			NativeHistogramBucketFactor: opts.NativeHistogramBucketFactor,
+		},
+		cronLabels,
+	)
+
+	pm.CronsHookFailCounter = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        genMetricName("hook_failures"),
+			Help:        opts.LabelMode.Help("count of failed hook executions"),
+			ConstLabels: opts.ConstLabels,
+		},
+		append(opts.LabelMode.Names(), "hook"),
+	)
+
+	pm.CronsOutputDroppedCounter = *prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name:        genMetricName("output_dropped_lines"),
+			Help:        opts.LabelMode.Help("count of lines of cron output that were not logged because of output limits"),
+			ConstLabels: opts.ConstLabels,
+		},
+		append(opts.LabelMode.Names(), "channel"),
+	)
+
+	pm.CronsLastStartGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("last_start_timestamp_seconds"),
+			Help:        opts.LabelMode.Help("time the last cron execution started, in seconds since the epoch"),
+			ConstLabels: opts.ConstLabels,
+		},
+		cronLabels,
+	)
+
+	pm.CronsLastSuccessGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("last_success_timestamp_seconds"),
+			Help:        opts.LabelMode.Help("time the last successful cron execution finished, in seconds since the epoch"),
+			ConstLabels: opts.ConstLabels,
 		},
 		cronLabels,
 	)
-	prometheus.MustRegister(pm.CronsExecutionTimeHistogram)
 
-	return pm
+	pm.CronsLastFailureGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("last_failure_timestamp_seconds"),
+			Help:        opts.LabelMode.Help("time the last failed cron execution finished, in seconds since the epoch"),
+			ConstLabels: opts.ConstLabels,
+		},
+		cronLabels,
+	)
+
+	pm.CronsLastDurationGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("last_duration_seconds"),
+			Help:        opts.LabelMode.Help("duration of the last cron execution"),
+			ConstLabels: opts.ConstLabels,
+		},
+		cronLabels,
+	)
+
+	pm.CronsLastExitCodeGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("last_exit_code"),
+			Help:        opts.LabelMode.Help("exit code of the last cron execution (-1 if the command could not be run)"),
+			ConstLabels: opts.ConstLabels,
+		},
+		cronLabels,
+	)
+
+	pm.CronsNextRunGauge = *prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name:        genMetricName("next_run_timestamp_seconds"),
+			Help:        opts.LabelMode.Help("time the next cron execution is scheduled for, in seconds since the epoch"),
+			ConstLabels: opts.ConstLabels,
+		},
+		cronLabels,
+	)
+
+	for _, vec := range pm.vecs() {
+		if err := registerer.Register(vec); err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+
+	if opts.RuntimeMetrics {
+		collectors := []prometheus.Collector{
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		}
+
+		for _, c := range collectors {
+			if err := registerer.Register(c); err != nil {
+				return nil, fmt.Errorf("failed to register runtime metrics: %w", err)
+			}
+		}
+	}
+
+	return pm, nil
+}
+
+// Prune removes the metrics of jobs that are not in jobs, e.g. after the
+// crontab was reloaded. Jobs are identified by their labels, so the metrics
+// of jobs whose labels did not change are kept, and their counters keep
+// increasing.
+func (p *PrometheusMetrics) Prune(jobs []*crontab.Job) {
+	keep := map[string]bool{}
+	for _, job := range jobs {
+		keep[p.labelsKey(p.JobLabels(job))] = true
+	}
+
+	for _, vec := range p.vecs() {
+		for _, labels := range collectLabels(vec) {
+			if keep[p.labelsKey(labels)] {
+				continue
+			}
+
+			for name := range p.constLabels {
+				delete(labels, name)
+			}
+			vec.Delete(labels)
+		}
+	}
+}
+
+type metricVec interface {
+	prometheus.Collector
+	Delete(prometheus.Labels) bool
+}
+
+func (p *PrometheusMetrics) vecs() []metricVec {
+	return []metricVec{
+		&p.CronsCurrentlyRunningGauge,
+		&p.CronsExecCounter,
+		&p.CronsSuccessCounter,
+		&p.CronsFailCounter,
+		&p.CronsDeadlineExceededCounter,
+		&p.CronsExecutionTimeHistogram,
+		&p.CronsHookFailCounter,
+		&p.CronsOutputDroppedCounter,
+		&p.CronsLastStartGauge,
+		&p.CronsLastSuccessGauge,
+		&p.CronsLastFailureGauge,
+		&p.CronsLastDurationGauge,
+		&p.CronsLastExitCodeGauge,
+		&p.CronsNextRunGauge,
+	}
+}
+
+// collectLabels returns the label sets of the metrics collected by c.
+func collectLabels(c prometheus.Collector) []prometheus.Labels {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var labelSets []prometheus.Labels
+	for m := range ch {
+		var metric dto.Metric
+		if err := m.Write(&metric); err != nil {
+			continue
+		}
+
+		labels := prometheus.Labels{}
+		for _, pair := range metric.Label {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		labelSets = append(labelSets, labels)
+	}
+
+	return labelSets
+}
+
+// labelsKey identifies the job of a label set.
+func (p *PrometheusMetrics) labelsKey(labels prometheus.Labels) string {
+	key := ""
+	for _, name := range p.labelMode.Names() {
+		key += fmt.Sprintf("%q ", labels[name])
+	}
+	return key
+}
+
+// JobLabels returns the labels of the metrics of job.
+func (p *PrometheusMetrics) JobLabels(job *crontab.Job) prometheus.Labels {
+	return p.labelMode.Labels(job)
+}
+
+func (p *PrometheusMetrics) RunStarted(job *crontab.Job) {
+	labels := p.JobLabels(job)
+
+	p.CronsCurrentlyRunningGauge.With(labels).Inc()
+	p.CronsLastStartGauge.With(labels).SetToCurrentTime()
+}
+
+func (p *PrometheusMetrics) RunEnded(job *crontab.Job) {
+	p.CronsCurrentlyRunningGauge.With(p.JobLabels(job)).Dec()
+}
+
+func (p *PrometheusMetrics) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {
+	labels := p.JobLabels(job)
+
+	p.CronsExecutionTimeHistogram.With(labels).Observe(duration.Seconds())
+	p.CronsExecCounter.With(labels).Inc()
+	p.CronsLastDurationGauge.With(labels).Set(duration.Seconds())
+	p.CronsLastExitCodeGauge.With(labels).Set(float64(exitCode))
+
+	if exitCode == 0 {
+		p.CronsSuccessCounter.With(labels).Inc()
+		p.CronsLastSuccessGauge.With(labels).SetToCurrentTime()
+	} else {
+		p.CronsFailCounter.With(labels).Inc()
+		p.CronsLastFailureGauge.With(labels).SetToCurrentTime()
+	}
+}
+
+func (p *PrometheusMetrics) RunScheduled(job *crontab.Job, next time.Time) {
+	p.CronsNextRunGauge.With(p.JobLabels(job)).Set(float64(next.UnixNano()) / 1e9)
+}
+
+func (p *PrometheusMetrics) DeadlineExceeded(job *crontab.Job) {
+	p.CronsDeadlineExceededCounter.With(p.JobLabels(job)).Inc()
+}
+
+func (p *PrometheusMetrics) HookFailed(job *crontab.Job, hook string) {
+	labels := p.JobLabels(job)
+	labels["hook"] = hook
+	p.CronsHookFailCounter.With(labels).Inc()
 }
 
-func (p *PrometheusMetrics) Reset() {
-	p.CronsCurrentlyRunningGauge.Reset()
-	p.CronsExecCounter.Reset()
-	p.CronsSuccessCounter.Reset()
-	p.CronsFailCounter.Reset()
-	p.CronsDeadlineExceededCounter.Reset()
-	p.CronsExecutionTimeHistogram.Reset()
+func (p *PrometheusMetrics) OutputDropped(job *crontab.Job, channel string, lines uint64) {
+	labels := p.JobLabels(job)
+	labels["channel"] = channel
+	p.CronsOutputDroppedCounter.With(labels).Add(float64(lines))
 }
 
 func getAddr(listenAddr string) (string, error) {
@@ -129,17 +396,19 @@ func getAddr(listenAddr string) (string, error) {
 
 }
 
-func InitHTTPServer(listenAddr string, shutdownContext context.Context) (func() error, error) {
+// InitHTTPServer serves the metrics gathered by gatherer at /metrics.
+func InitHTTPServer(listenAddr string, shutdownContext context.Context, gatherer prometheus.Gatherer) (func() error, error) {
 	addr, err := getAddr(listenAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	promSrv := &http.Server{}
+	mux := http.NewServeMux()
+	promSrv := &http.Server{Handler: mux}
 
-	http.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
              <head><title>Supercronic</title></head>
              <body>
@@ -149,7 +418,7 @@ func InitHTTPServer(listenAddr string, shutdownContext context.Context) (func()
              </html>`))
 	})
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`OK`))
 	})
 
@@ -2,8 +2,14 @@ package prometheus_metrics
 
 import (
 	"testing"
+	"time"
 
+	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetAddr(t *testing.T) {
@@ -43,3 +49,269 @@ func TestGetAddr(t *testing.T) {
 	_, err = getAddr("[::]")
 	assert.NotNil(t, err)
 }
+
+func newTestMetrics(t *testing.T, opts Options) (*PrometheusMetrics, *prometheus.Registry) {
+	registry := prometheus.NewRegistry()
+
+	pm, err := NewPrometheusMetrics(registry, opts)
+	require.NoError(t, err)
+
+	return pm, registry
+}
+
+func TestRunGauges(t *testing.T) {
+	testMetrics, _ := newTestMetrics(t, Options{})
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "gauges", Command: "true"},
+	}
+	labels := testMetrics.JobLabels(job)
+
+	before := float64(time.Now().Unix())
+
+	testMetrics.RunStarted(job)
+	testMetrics.RunFinished(job, 1500*time.Millisecond, 2)
+	testMetrics.RunEnded(job)
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(testMetrics.CronsLastStartGauge.With(labels)), before)
+	assert.GreaterOrEqual(t, testutil.ToFloat64(testMetrics.CronsLastFailureGauge.With(labels)), before)
+	assert.Equal(t, 0.0, testutil.ToFloat64(testMetrics.CronsLastSuccessGauge.With(labels)))
+	assert.Equal(t, 1.5, testutil.ToFloat64(testMetrics.CronsLastDurationGauge.With(labels)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(testMetrics.CronsLastExitCodeGauge.With(labels)))
+
+	testMetrics.RunFinished(job, time.Second, 0)
+
+	assert.GreaterOrEqual(t, testutil.ToFloat64(testMetrics.CronsLastSuccessGauge.With(labels)), before)
+	assert.Equal(t, 0.0, testutil.ToFloat64(testMetrics.CronsLastExitCodeGauge.With(labels)))
+
+	next := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	testMetrics.RunScheduled(job, next)
+
+	assert.Equal(t, float64(next.Unix()), testutil.ToFloat64(testMetrics.CronsNextRunGauge.With(labels)))
+}
+
+func TestPruneKeepsUnchangedJobs(t *testing.T) {
+	testMetrics, _ := newTestMetrics(t, Options{})
+
+	unchanged := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "prune", Command: "unchanged"},
+	}
+	removed := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "prune", Command: "removed"},
+		Position:    1,
+	}
+
+	for _, job := range []*crontab.Job{unchanged, removed} {
+		testMetrics.RunStarted(job)
+		testMetrics.RunFinished(job, time.Second, 0)
+		testMetrics.RunFinished(job, time.Second, 1)
+		testMetrics.RunEnded(job)
+		testMetrics.RunScheduled(job, time.Now())
+		testMetrics.DeadlineExceeded(job)
+		testMetrics.HookFailed(job, "after")
+		testMetrics.OutputDropped(job, "stdout", 1)
+	}
+
+	testMetrics.Prune([]*crontab.Job{unchanged})
+
+	for _, vec := range testMetrics.vecs() {
+		assert.True(t, hasLabels(testMetrics, vec, testMetrics.JobLabels(unchanged)))
+		assert.False(t, hasLabels(testMetrics, vec, testMetrics.JobLabels(removed)))
+	}
+}
+
+func TestCountersStayMonotonicAcrossReloads(t *testing.T) {
+	testMetrics, _ := newTestMetrics(t, Options{})
+
+	kept := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "reload", Command: "kept"},
+	}
+	changed := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "reload", Command: "changed"},
+		Position:    1,
+	}
+	changedAgain := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "reload", Command: "changed again"},
+		Position:    1,
+	}
+
+	run := func(job *crontab.Job) {
+		testMetrics.RunStarted(job)
+		testMetrics.RunFinished(job, time.Second, 0)
+		testMetrics.RunEnded(job)
+	}
+
+	executions := func(job *crontab.Job) float64 {
+		return testutil.ToFloat64(testMetrics.CronsExecCounter.With(testMetrics.JobLabels(job)))
+	}
+
+	run(kept)
+	run(changed)
+
+	// First reload: the changed job is pruned, the other one is kept
+	testMetrics.Prune([]*crontab.Job{kept, changedAgain})
+	assert.Equal(t, 1.0, executions(kept))
+	assert.False(t, hasLabels(testMetrics, &testMetrics.CronsExecCounter, testMetrics.JobLabels(changed)))
+
+	run(kept)
+	run(changedAgain)
+	assert.Equal(t, 2.0, executions(kept))
+
+	// Second reload, with the same crontab: nothing is lost
+	testMetrics.Prune([]*crontab.Job{kept, changedAgain})
+	assert.Equal(t, 2.0, executions(kept))
+	assert.Equal(t, 1.0, executions(changedAgain))
+
+	run(kept)
+	assert.Equal(t, 3.0, executions(kept))
+	assert.Equal(t, 3.0, testutil.ToFloat64(testMetrics.CronsSuccessCounter.With(testMetrics.JobLabels(kept))))
+}
+
+func TestNewPrometheusMetricsOptions(t *testing.T) {
+	testMetrics, registry := newTestMetrics(t, Options{
+		Namespace:   "cron",
+		ConstLabels: prometheus.Labels{"instance_group": "workers"},
+		Buckets:     []float64{1, 5},
+	})
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "options", Command: "true"},
+	}
+	testMetrics.RunFinished(job, 3*time.Second, 0)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	found := false
+	for _, family := range families {
+		assert.Regexp(t, "^cron_", family.GetName())
+
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			assert.Equal(t, "workers", labels["instance_group"])
+		}
+
+		if family.GetName() == "cron_cron_execution_time_seconds" {
+			found = true
+
+			buckets := family.GetMetric()[0].GetHistogram().GetBucket()
+			require.Len(t, buckets, 2)
+			assert.Equal(t, 1.0, buckets[0].GetUpperBound())
+			assert.Equal(t, uint64(0), buckets[0].GetCumulativeCount())
+			assert.Equal(t, 5.0, buckets[1].GetUpperBound())
+			assert.Equal(t, uint64(1), buckets[1].GetCumulativeCount())
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestNewPrometheusMetricsRuntimeMetrics(t *testing.T) {
+	_, registry := newTestMetrics(t, Options{RuntimeMetrics: true})
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	names := []string{}
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	assert.Contains(t, names, "go_goroutines")
+}
+
+func TestNewPrometheusMetricsTwice(t *testing.T) {
+	// Separate registries do not conflict
+	newTestMetrics(t, Options{})
+	newTestMetrics(t, Options{})
+
+	// The same registry does, but does not panic
+	registry := prometheus.NewRegistry()
+	_, err := NewPrometheusMetrics(registry, Options{})
+	require.NoError(t, err)
+	_, err = NewPrometheusMetrics(registry, Options{})
+	assert.Error(t, err)
+}
+
+func TestPruneWithConstLabels(t *testing.T) {
+	testMetrics, _ := newTestMetrics(t, Options{ConstLabels: prometheus.Labels{"env": "test"}})
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "prune", Command: "removed"},
+	}
+	testMetrics.RunFinished(job, time.Second, 0)
+
+	testMetrics.Prune(nil)
+	assert.False(t, hasLabels(testMetrics, &testMetrics.CronsExecCounter, testMetrics.JobLabels(job)))
+}
+
+func TestLabelModes(t *testing.T) {
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{Schedule: "* * * * *", Command: "backup.sh --token secret"},
+		Position:    3,
+		Name:        "backup",
+	}
+
+	cases := []struct {
+		mode     metrics.LabelMode
+		expected prometheus.Labels
+	}{
+		{metrics.LabelsName, prometheus.Labels{"job_name": "backup"}},
+		{metrics.LabelsPositionSchedule, prometheus.Labels{"position": "3", "schedule": "* * * * *"}},
+		{metrics.LabelsCommandHash, prometheus.Labels{"command_hash": metrics.CommandHash(job.Command), "position": "3", "schedule": "* * * * *"}},
+		{metrics.LabelsFull, prometheus.Labels{"command": job.Command, "position": "3", "schedule": "* * * * *"}},
+		{"", prometheus.Labels{"command": job.Command, "position": "3", "schedule": "* * * * *"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			testMetrics, registry := newTestMetrics(t, Options{LabelMode: tc.mode})
+			assert.Equal(t, tc.expected, testMetrics.JobLabels(job))
+
+			testMetrics.RunStarted(job)
+			testMetrics.RunFinished(job, time.Second, 0)
+			testMetrics.RunFinished(job, time.Second, 1)
+			testMetrics.RunEnded(job)
+			testMetrics.RunScheduled(job, time.Now())
+			testMetrics.DeadlineExceeded(job)
+			testMetrics.HookFailed(job, "after")
+			testMetrics.OutputDropped(job, "stdout", 1)
+
+			families, err := registry.Gather()
+			require.NoError(t, err)
+			assert.Len(t, families, len(testMetrics.vecs()))
+
+			// Every family is labelled the same way, and its help
+			// explains how to switch modes
+			for _, family := range families {
+				assert.Contains(t, family.GetHelp(), `label mode "full"`, family.GetName())
+
+				for _, m := range family.GetMetric() {
+					labels := prometheus.Labels{}
+					for _, l := range m.GetLabel() {
+						if l.GetName() != "hook" && l.GetName() != "channel" {
+							labels[l.GetName()] = l.GetValue()
+						}
+					}
+					assert.Equal(t, tc.expected, labels, family.GetName())
+				}
+			}
+
+			testMetrics.Prune([]*crontab.Job{job})
+			assert.True(t, hasLabels(testMetrics, &testMetrics.CronsExecCounter, tc.expected))
+
+			testMetrics.Prune(nil)
+			assert.False(t, hasLabels(testMetrics, &testMetrics.CronsExecCounter, tc.expected))
+		})
+	}
+}
+
+func hasLabels(pm *PrometheusMetrics, c prometheus.Collector, labels prometheus.Labels) bool {
+	for _, l := range collectLabels(c) {
+		if pm.labelsKey(l) == pm.labelsKey(labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package prometheus_metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"github.com/sirupsen/logrus"
+)
+
+// PushConfig configures a Pusher. Metrics are pushed to the group identified
+// by Job and Grouping on the Pushgateway at URL.
+type PushConfig struct {
+	URL          string
+	Job          string
+	Grouping     map[string]string
+	Username     string
+	Password     string
+	Timeout      time.Duration
+	Retries      int
+	Backoff      time.Duration
+	DeleteOnExit bool
+}
+
+// Pusher pushes metrics to a Prometheus Pushgateway after each job run, for
+// instances that may exit before they are scraped. Pushes happen in the
+// background: runs that end while a push is in progress are covered by a
+// single push once it completes. Pusher implements metrics.Recorder, and
+// should be registered after the recorders whose metrics it pushes.
+type Pusher struct {
+	config PushConfig
+	pusher *push.Pusher
+	logger *logrus.Entry
+
+	pending chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func NewPusher(config PushConfig, gatherer prometheus.Gatherer, logger *logrus.Entry) *Pusher {
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	if config.Backoff == 0 {
+		config.Backoff = time.Second
+	}
+
+	pusher := push.New(config.URL, config.Job).
+		Gatherer(gatherer).
+		Client(&http.Client{Timeout: config.Timeout})
+
+	for name, value := range config.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+
+	if config.Username != "" || config.Password != "" {
+		pusher = pusher.BasicAuth(config.Username, config.Password)
+	}
+
+	p := &Pusher{
+		config:  config,
+		pusher:  pusher,
+		logger:  logger,
+		pending: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p
+}
+
+func (p *Pusher) run() {
+	defer close(p.stopped)
+
+	for {
+		select {
+		case <-p.pending:
+			p.push()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Trigger schedules a push, without waiting for it.
+func (p *Pusher) Trigger() {
+	select {
+	case p.pending <- struct{}{}:
+	default:
+		// A push is already pending, and will include the latest
+		// metrics
+	}
+}
+
+func (p *Pusher) push() {
+	p.retry("push", p.pusher.Push)
+}
+
+func (p *Pusher) retry(action string, fn func() error) {
+	backoff := p.config.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return
+		}
+
+		if attempt >= p.config.Retries {
+			p.logger.Warnf("failed to %s metrics to pushgateway: %v", action, err)
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Shutdown stops pushing after each run, and pushes the metrics one last
+// time. If DeleteOnExit is set, it deletes the group from the Pushgateway
+// instead.
+func (p *Pusher) Shutdown() {
+	if p == nil {
+		return
+	}
+
+	p.once.Do(func() {
+		close(p.done)
+		<-p.stopped
+
+		if p.config.DeleteOnExit {
+			p.retry("delete", p.pusher.Delete)
+		} else {
+			p.push()
+		}
+	})
+}
+
+func (p *Pusher) RunStarted(job *crontab.Job) {}
+
+func (p *Pusher) RunEnded(job *crontab.Job) {
+	p.Trigger()
+}
+
+func (p *Pusher) RunFinished(job *crontab.Job, duration time.Duration, exitCode int) {}
+
+func (p *Pusher) RunScheduled(job *crontab.Job, next time.Time) {}
+
+func (p *Pusher) DeadlineExceeded(job *crontab.Job) {}
+
+func (p *Pusher) HookFailed(job *crontab.Job, hook string) {}
+
+func (p *Pusher) OutputDropped(job *crontab.Job, channel string, lines uint64) {}
@@ -0,0 +1,188 @@
+package prometheus_metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type pushRequest struct {
+	method string
+	path   string
+	user   string
+	body   string
+}
+
+// newPushgateway starts a stand-in for a Pushgateway, which fails the first
+// failures requests.
+func newPushgateway(failures int) (*httptest.Server, func() []pushRequest) {
+	var mu sync.Mutex
+	requests := []pushRequest{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		user, _, _ := r.BasicAuth()
+
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, pushRequest{r.Method, r.URL.Path, user, string(body)})
+
+		if len(requests) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return srv, func() []pushRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]pushRequest{}, requests...)
+	}
+}
+
+func newTestRegistry() (*prometheus.Registry, prometheus.Counter) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "supercronic_executions"})
+	registry.MustRegister(counter)
+	return registry, counter
+}
+
+func newTestPusher(config PushConfig, gatherer prometheus.Gatherer) *Pusher {
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	config.Backoff = time.Millisecond
+	return NewPusher(config, gatherer, logrus.NewEntry(logger))
+}
+
+func waitForRequests(t *testing.T, requests func() []pushRequest, n int) []pushRequest {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if r := requests(); len(r) >= n {
+			return r
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("timed out waiting for %d requests", n)
+	return nil
+}
+
+func TestPusherPushesAfterRuns(t *testing.T) {
+	srv, requests := newPushgateway(0)
+	defer srv.Close()
+
+	registry, counter := newTestRegistry()
+
+	p := newTestPusher(PushConfig{
+		URL:      srv.URL,
+		Job:      "cron",
+		Grouping: map[string]string{"instance": "batch-1"},
+		Username: "user",
+		Password: "secret",
+	}, registry)
+
+	counter.Inc()
+	p.RunEnded(&crontab.Job{})
+
+	r := waitForRequests(t, requests, 1)
+	assert.Equal(t, http.MethodPut, r[0].method)
+	assert.Equal(t, "/metrics/job/cron/instance/batch-1", r[0].path)
+	assert.Equal(t, "user", r[0].user)
+
+	p.Shutdown()
+
+	r = requests()
+	assert.Len(t, r, 2)
+	assert.Equal(t, http.MethodPut, r[1].method)
+}
+
+func TestPusherRetries(t *testing.T) {
+	srv, requests := newPushgateway(2)
+	defer srv.Close()
+
+	registry, _ := newTestRegistry()
+
+	p := newTestPusher(PushConfig{URL: srv.URL, Job: "cron", Retries: 2}, registry)
+	p.Trigger()
+
+	r := waitForRequests(t, requests, 3)
+	assert.Len(t, r, 3)
+
+	p.Shutdown()
+	assert.Len(t, requests(), 4)
+}
+
+func TestPusherGivesUp(t *testing.T) {
+	srv, requests := newPushgateway(100)
+	defer srv.Close()
+
+	registry, _ := newTestRegistry()
+
+	p := newTestPusher(PushConfig{URL: srv.URL, Job: "cron", Retries: 1}, registry)
+	p.Shutdown()
+
+	assert.Len(t, requests(), 2)
+}
+
+func TestPusherDeletesOnExit(t *testing.T) {
+	srv, requests := newPushgateway(0)
+	defer srv.Close()
+
+	registry, _ := newTestRegistry()
+
+	p := newTestPusher(PushConfig{URL: srv.URL, Job: "cron", DeleteOnExit: true}, registry)
+	p.Shutdown()
+	p.Shutdown()
+
+	r := requests()
+	if assert.Len(t, r, 1) {
+		assert.Equal(t, http.MethodDelete, r[0].method)
+		assert.Equal(t, "/metrics/job/cron", r[0].path)
+	}
+}
+
+func TestPusherDoesNotBlockRuns(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	registry, _ := newTestRegistry()
+
+	p := newTestPusher(PushConfig{URL: srv.URL, Job: "cron"}, registry)
+	defer p.Shutdown()
+
+	t0 := time.Now()
+	for i := 0; i < 100; i++ {
+		p.RunEnded(&crontab.Job{})
+	}
+	assert.Less(t, time.Since(t0), 100*time.Millisecond)
+}
+
+func TestPusherBody(t *testing.T) {
+	srv, requests := newPushgateway(0)
+	defer srv.Close()
+
+	registry, counter := newTestRegistry()
+	counter.Add(3)
+
+	p := newTestPusher(PushConfig{URL: srv.URL, Job: "cron"}, registry)
+	p.Shutdown()
+
+	r := requests()
+	if assert.Len(t, r, 1) {
+		assert.True(t, strings.Contains(r[0].body, "supercronic_executions"), "body should contain the metrics")
+	}
+}
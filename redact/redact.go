@@ -0,0 +1,160 @@
+package redact
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	Mask = "[REDACTED]"
+)
+
+// Redactor masks secret values and matches of patterns in strings. Secrets
+// can be replaced at any time (e.g. when the crontab is reloaded). A nil
+// *Redactor leaves strings untouched.
+type Redactor struct {
+	patterns []*regexp.Regexp
+
+	mu       sync.RWMutex
+	replacer *strings.Replacer
+}
+
+func New(patterns []*regexp.Regexp) *Redactor {
+	return &Redactor{patterns: patterns}
+}
+
+// SetSecrets replaces the secret values masked by the redactor.
+func (r *Redactor) SetSecrets(secrets []string) {
+	unique := make(map[string]bool)
+	for _, s := range secrets {
+		if s != "" {
+			unique[s] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(unique))
+	for s := range unique {
+		sorted = append(sorted, s)
+	}
+
+	// Replace longer secrets first, in case a secret contains another
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+
+	var replacer *strings.Replacer
+	if len(sorted) > 0 {
+		oldnew := make([]string, 0, 2*len(sorted))
+		for _, s := range sorted {
+			oldnew = append(oldnew, s, Mask)
+		}
+		replacer = strings.NewReplacer(oldnew...)
+	}
+
+	r.mu.Lock()
+	r.replacer = replacer
+	r.mu.Unlock()
+}
+
+// Redact masks the secrets and pattern matches found in s. For patterns with
+// capture groups, only the groups are masked.
+func (r *Redactor) Redact(s string) string {
+	if r == nil {
+		return s
+	}
+
+	r.mu.RLock()
+	replacer := r.replacer
+	r.mu.RUnlock()
+
+	if replacer != nil {
+		s = replacer.Replace(s)
+	}
+
+	for _, p := range r.patterns {
+		s = redactPattern(p, s)
+	}
+
+	return s
+}
+
+func (r *Redactor) RedactAll(lines []string) []string {
+	if r == nil {
+		return lines
+	}
+
+	redacted := make([]string, len(lines))
+	for i, l := range lines {
+		redacted[i] = r.Redact(l)
+	}
+
+	return redacted
+}
+
+func redactPattern(p *regexp.Regexp, s string) string {
+	if p.NumSubexp() == 0 {
+		return p.ReplaceAllLiteralString(s, Mask)
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, m := range p.FindAllStringSubmatchIndex(s, -1) {
+		for g := 1; g <= p.NumSubexp(); g++ {
+			start, end := m[2*g], m[2*g+1]
+			if start < last || start < 0 {
+				continue
+			}
+
+			b.WriteString(s[last:start])
+			b.WriteString(Mask)
+			last = end
+		}
+	}
+
+	b.WriteString(s[last:])
+
+	return b.String()
+}
+
+// EnvSecrets returns the values of the named variables, looked up in environ
+// first, and in the environment of the process otherwise.
+func EnvSecrets(names []string, environ map[string]string) []string {
+	secrets := []string{}
+
+	for _, name := range names {
+		if v, ok := environ[name]; ok {
+			secrets = append(secrets, v)
+		}
+
+		if v, ok := os.LookupEnv(name); ok {
+			secrets = append(secrets, v)
+		}
+	}
+
+	return secrets
+}
+
+// FileSecrets returns the contents of the given files, with surrounding
+// whitespace removed.
+func FileSecrets(paths []string) ([]string, error) {
+	secrets := []string{}
+
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret file: %w", err)
+		}
+
+		secrets = append(secrets, strings.TrimSpace(string(b)))
+	}
+
+	return secrets, nil
+}
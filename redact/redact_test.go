@@ -0,0 +1,63 @@
+package redact
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactSecrets(t *testing.T) {
+	r := New(nil)
+	r.SetSecrets([]string{"abc", "abcdef", ""})
+
+	assert.Equal(t, "token=[REDACTED] other=[REDACTED]!", r.Redact("token=abcdef other=abc!"))
+	assert.Equal(t, "nothing here", r.Redact("nothing here"))
+
+	r.SetSecrets(nil)
+	assert.Equal(t, "abc", r.Redact("abc"))
+}
+
+func TestRedactPatterns(t *testing.T) {
+	r := New([]*regexp.Regexp{
+		regexp.MustCompile(`ghp_[A-Za-z0-9]+`),
+		regexp.MustCompile(`(?i)password=(\S+)`),
+	})
+
+	assert.Equal(t,
+		"curl -H [REDACTED] --data password=[REDACTED] PASSWORD=[REDACTED]",
+		r.Redact("curl -H ghp_XyZ123 --data password=hunter2 PASSWORD=x"),
+	)
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	assert.Equal(t, "abc", r.Redact("abc"))
+	assert.Equal(t, []string{"abc"}, r.RedactAll([]string{"abc"}))
+}
+
+func TestEnvSecrets(t *testing.T) {
+	t.Setenv("REDACT_TEST_TOKEN", "from-env")
+
+	secrets := EnvSecrets(
+		[]string{"REDACT_TEST_TOKEN", "REDACT_TEST_CRONTAB", "REDACT_TEST_UNSET"},
+		map[string]string{"REDACT_TEST_CRONTAB": "from-crontab"},
+	)
+
+	assert.ElementsMatch(t, []string{"from-env", "from-crontab"}, secrets)
+}
+
+func TestFileSecrets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.Nil(t, os.WriteFile(path, []byte("  s3cr3t\n"), 0600))
+
+	secrets, err := FileSecrets([]string{path})
+	if assert.Nil(t, err) {
+		assert.Equal(t, []string{"s3cr3t"}, secrets)
+	}
+
+	_, err = FileSecrets([]string{path + ".missing"})
+	assert.NotNil(t, err)
+}
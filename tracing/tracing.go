@@ -0,0 +1,174 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/otlp"
+	"github.com/aptible/supercronic/redact"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config configures a Tracer. Endpoint is the URL of the OTLP collector,
+// e.g. http://localhost:4318 for OTLP over HTTP, or http://localhost:4317
+// for gRPC. http:// endpoints are used without TLS. Headers, compression and
+// other settings can be set with the standard OTEL_EXPORTER_OTLP_*
+// variables, and resource attributes with OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES. Redactor masks secrets in the commands of jobs,
+// like in logs.
+type Config struct {
+	Endpoint string
+	Protocol otlp.Protocol
+	Timeout  time.Duration
+	Redactor *redact.Redactor
+}
+
+// Tracer emits a span for each job run. Spans are exported in batches in the
+// background: a slow or unreachable collector never delays jobs, and spans
+// are dropped if they cannot be queued. A nil *Tracer emits nothing.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	redactor *redact.Redactor
+}
+
+// NewTracer creates a Tracer exporting to the collector of config. Export
+// errors are reported to the OpenTelemetry error handler (see
+// otel.SetErrorHandler).
+func NewTracer(config Config) (*Tracer, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint: %q", config.Endpoint)
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	var client otlptrace.Client
+	switch config.Protocol {
+	case otlp.ProtocolHTTP, "":
+		client = otlptracehttp.NewClient(
+			otlptracehttp.WithEndpointURL(config.Endpoint),
+			otlptracehttp.WithTimeout(config.Timeout),
+		)
+	case otlp.ProtocolGRPC:
+		client = otlptracegrpc.NewClient(
+			otlptracegrpc.WithEndpointURL(config.Endpoint),
+			otlptracegrpc.WithTimeout(config.Timeout),
+		)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol: %q", config.Protocol)
+	}
+
+	// Creating the exporter does not connect to the collector: this never
+	// fails because the collector is down.
+	exporter, err := otlptrace.New(context.Background(), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(otlp.Resource()),
+	)
+
+	return &Tracer{
+		provider: provider,
+		tracer:   provider.Tracer(otlp.InstrumentationName),
+		redactor: config.Redactor,
+	}, nil
+}
+
+// Span is the span of a single run.
+type Span struct {
+	ctx  context.Context
+	span trace.Span
+}
+
+// Start starts the span of a run of job.
+func (t *Tracer) Start(job *crontab.Job, iteration uint64) *Span {
+	if t == nil {
+		return nil
+	}
+
+	ctx, span := t.tracer.Start(
+		context.Background(),
+		job.Name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("job.name", job.Name),
+			attribute.String("job.schedule", job.Schedule),
+			attribute.String("job.command", t.redactor.Redact(job.Command)),
+			attribute.Int("job.position", job.Position),
+			attribute.Int64("job.iteration", int64(iteration)),
+			// Supercronic never retries a run: a failed run is
+			// retried by the next iteration, which gets its own span
+			attribute.Int("job.attempt", 1),
+		),
+	)
+
+	return &Span{ctx: ctx, span: span}
+}
+
+// Environ returns the variables that let the job attach its own spans to
+// the span of the run: TRACEPARENT, and TRACESTATE if there is one.
+func (s *Span) Environ() map[string]string {
+	if s == nil {
+		return nil
+	}
+
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(s.ctx, carrier)
+
+	environ := map[string]string{}
+	if v := carrier.Get("traceparent"); v != "" {
+		environ["TRACEPARENT"] = v
+	}
+	if v := carrier.Get("tracestate"); v != "" {
+		environ["TRACESTATE"] = v
+	}
+
+	return environ
+}
+
+// Finish ends the span of the run. Runs that failed get an error status.
+func (s *Span) Finish(exitCode int, err error) {
+	if s == nil {
+		return
+	}
+
+	s.span.SetAttributes(attribute.Int("process.exit.code", exitCode))
+
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	} else {
+		s.span.SetStatus(codes.Ok, "")
+	}
+
+	s.span.End()
+}
+
+// Shutdown exports the spans that are still queued, giving up after
+// timeout.
+func (t *Tracer) Shutdown(timeout time.Duration) error {
+	if t == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return t.provider.Shutdown(ctx)
+}
@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aptible/supercronic/crontab"
+	"github.com/aptible/supercronic/otlp"
+	"github.com/aptible/supercronic/redact"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newCollector starts a stand-in for an OTLP collector, which records the
+// spans it receives, or fails every export with status.
+func newCollector(status int) (*httptest.Server, func() []*tracepb.Span) {
+	var mu sync.Mutex
+	spans := []*tracepb.Span{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		req := &collectortrace.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		w.Write(out)
+	}))
+
+	return srv, func() []*tracepb.Span {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]*tracepb.Span{}, spans...)
+	}
+}
+
+func newTestTracer(t *testing.T, endpoint string) *Tracer {
+	tracer, err := NewTracer(Config{Endpoint: endpoint, Protocol: otlp.ProtocolHTTP})
+	require.NoError(t, err)
+
+	return tracer
+}
+
+var testJob = &crontab.Job{
+	CrontabLine: crontab.CrontabLine{
+		Schedule: "*/5 * * * *",
+		Command:  "backup.sh",
+	},
+	Position: 2,
+	Name:     "backup",
+}
+
+func spanAttributes(span *tracepb.Span) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes {
+		switch v := kv.Value.Value.(type) {
+		case *commonpb.AnyValue_IntValue:
+			attrs[kv.Key] = fmt.Sprintf("%d", v.IntValue)
+		case *commonpb.AnyValue_StringValue:
+			attrs[kv.Key] = v.StringValue
+		}
+	}
+	return attrs
+}
+
+func TestTracerExportsSpans(t *testing.T) {
+	srv, spans := newCollector(http.StatusOK)
+	defer srv.Close()
+
+	tracer := newTestTracer(t, srv.URL)
+
+	ok := tracer.Start(testJob, 3)
+	ok.Finish(0, nil)
+
+	failed := tracer.Start(testJob, 4)
+	environ := failed.Environ()
+	failed.Finish(1, fmt.Errorf("error running command: exit status 1"))
+
+	require.NoError(t, tracer.Shutdown(5*time.Second))
+
+	got := spans()
+	require.Len(t, got, 2)
+
+	assert.Equal(t, "backup", got[0].Name)
+	assert.Equal(t, map[string]string{
+		"job.name":          "backup",
+		"job.schedule":      "*/5 * * * *",
+		"job.command":       "backup.sh",
+		"job.position":      "2",
+		"job.iteration":     "3",
+		"job.attempt":       "1",
+		"process.exit.code": "0",
+	}, spanAttributes(got[0]))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_OK, got[0].Status.Code)
+
+	assert.Equal(t, "4", spanAttributes(got[1])["job.iteration"])
+	assert.Equal(t, "1", spanAttributes(got[1])["process.exit.code"])
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, got[1].Status.Code)
+	assert.Equal(t, "error running command: exit status 1", got[1].Status.Message)
+
+	// The job can attach its spans to the span of its run
+	assert.Regexp(t, regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`), environ["TRACEPARENT"])
+	assert.Equal(
+		t,
+		fmt.Sprintf("00-%x-%x-01", got[1].TraceId, got[1].SpanId),
+		environ["TRACEPARENT"],
+	)
+}
+
+func TestTracerRedactsCommand(t *testing.T) {
+	srv, spans := newCollector(http.StatusOK)
+	defer srv.Close()
+
+	redactor := redact.New([]*regexp.Regexp{regexp.MustCompile(`--token=(\S+)`)})
+	redactor.SetSecrets([]string{"hunter2"})
+
+	tracer, err := NewTracer(Config{Endpoint: srv.URL, Protocol: otlp.ProtocolHTTP, Redactor: redactor})
+	require.NoError(t, err)
+
+	job := &crontab.Job{
+		CrontabLine: crontab.CrontabLine{
+			Schedule: "*/5 * * * *",
+			Command:  "backup.sh --token=abc123 --password hunter2",
+		},
+		Name: "backup",
+	}
+
+	tracer.Start(job, 0).Finish(0, nil)
+	require.NoError(t, tracer.Shutdown(5*time.Second))
+
+	got := spans()
+	require.Len(t, got, 1)
+
+	command := spanAttributes(got[0])["job.command"]
+	assert.NotContains(t, command, "abc123")
+	assert.NotContains(t, command, "hunter2")
+	assert.Contains(t, command, "backup.sh --token=")
+}
+
+func TestTracerDoesNotBlockOnExportFailures(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusServiceUnavailable} {
+		t.Run(fmt.Sprintf("%d", status), func(t *testing.T) {
+			srv, _ := newCollector(status)
+			defer srv.Close()
+
+			tracer := newTestTracer(t, srv.URL)
+
+			t0 := time.Now()
+			for i := 0; i < 100; i++ {
+				tracer.Start(testJob, uint64(i)).Finish(0, nil)
+			}
+			assert.Less(t, time.Since(t0), time.Second)
+
+			// Retries give up when shutdown times out
+			t0 = time.Now()
+			tracer.Shutdown(500 * time.Millisecond)
+			assert.Less(t, time.Since(t0), 2*time.Second)
+		})
+	}
+}
+
+func TestTracerUnreachableCollector(t *testing.T) {
+	srv, _ := newCollector(http.StatusOK)
+	srv.Close()
+
+	tracer := newTestTracer(t, srv.URL)
+
+	t0 := time.Now()
+	tracer.Start(testJob, 0).Finish(0, nil)
+	assert.Less(t, time.Since(t0), time.Second)
+
+	t0 = time.Now()
+	tracer.Shutdown(500 * time.Millisecond)
+	assert.Less(t, time.Since(t0), 2*time.Second)
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+
+	span := tracer.Start(testJob, 0)
+	assert.Nil(t, span.Environ())
+	span.Finish(1, fmt.Errorf("failed"))
+	assert.NoError(t, tracer.Shutdown(time.Second))
+}
+
+func TestNewTracerInvalidConfig(t *testing.T) {
+	_, err := NewTracer(Config{Endpoint: "localhost"})
+	assert.Error(t, err)
+
+	_, err = NewTracer(Config{Endpoint: "http://localhost:4318", Protocol: "udp"})
+	assert.Error(t, err)
+}